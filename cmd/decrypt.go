@@ -24,12 +24,15 @@ For example:
 			log.Fatal("failed to get url: ", err)
 		}
 
-		message, err := read(url)
+		ctx, cancel := newContext()
+		defer cancel()
+
+		message, err := read(ctx, url)
 		if err != nil {
 			log.Fatal("failed to read:", err)
 		}
 
-		result, err := secrets.Decrypt(message)
+		result, err := secrets.DecryptWithOptions(ctx, message, secrets.DecryptOptions{Name: url})
 		if err != nil {
 			log.Fatal("failed to decrypt: ", err)
 		}
@@ -47,4 +50,5 @@ For example:
 func init() {
 	RootCmd.AddCommand(decryptCmd)
 	decryptCmd.Flags().BoolVarP(&inplace, "inplace", "i", false, "Decrypt file in-place")
+	decryptCmd.Flags().DurationVar(&timeout, "timeout", 0, "Give up if reading or decrypting takes longer than this (e.g. 30s)")
 }
@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
+	"sort"
 
 	"github.com/agilebits/eh/secrets"
 	"github.com/spf13/cobra"
@@ -17,11 +19,20 @@ var encryptCmd = &cobra.Command{
 Encrypt command is used to encrypt the protected values in the contents of 
 the standard input and write result into the standard output. 
 
-The .hcl file must include the 'eh' section.
+The file must include the 'eh' section. HCL, HCL2, JSON, YAML, TOML and
+dotenv files are supported.
 
 For example:
 
 	eh encrypt -i app-config.hcl
+
+A summary of the protected values is written to the standard error. Use --strict
+to fail if an entry in the protect list does not match any value, or if a
+protected value cannot be encrypted.
+
+To keep the ciphertext of values that did not change since the last commit:
+
+	eh encrypt -i --previous-head app-config.hcl
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		url, err := getURL(args)
@@ -29,12 +40,33 @@ For example:
 			log.Fatal("failed to get url: ", err)
 		}
 
-		message, err := read(url)
+		ctx, cancel := newContext()
+		defer cancel()
+
+		message, err := read(ctx, url)
 		if err != nil {
 			log.Fatal("failed to read:", err)
 		}
 
-		result, err := secrets.Encrypt(message)
+		var previousMessage []byte
+		switch {
+		case previousHead:
+			previousMessage, err = readGitHead(ctx, url)
+		case previous != "":
+			previousMessage, err = read(ctx, previous)
+		}
+		if err != nil {
+			log.Fatal("failed to read previous version:", err)
+		}
+
+		result, report, err := secrets.EncryptWithReport(ctx, message, secrets.EncryptOptions{
+			Name:     url,
+			Previous: previousMessage,
+			Strict:   strictProtect,
+		})
+		if report != nil {
+			printReport(report)
+		}
 		if err != nil {
 			log.Fatal("failed to encrypt:", err)
 		}
@@ -49,8 +81,37 @@ For example:
 	},
 }
 
+var previous string
+var previousHead bool
+var strictProtect bool
+
+// printReport writes the number of protected values to stderr, so that it does not mix with the encrypted output.
+func printReport(report *secrets.Report) {
+	names := make([]string, 0, len(report.Protected))
+	for name := range report.Protected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "%s: %d protected\n", name, report.Protected[name])
+	}
+
+	for _, name := range report.Unmatched {
+		fmt.Fprintf(os.Stderr, "warning: protected key %q did not match any value\n", name)
+	}
+
+	for _, value := range report.Unsupported {
+		fmt.Fprintf(os.Stderr, "warning: protected value %s cannot be encrypted and is left in plaintext\n", value)
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(encryptCmd)
 
 	encryptCmd.Flags().BoolVarP(&inplace, "inplace", "i", false, "Encrypt file in-place")
+	encryptCmd.Flags().StringVar(&previous, "previous", "", "Reuse the key and unchanged ciphertexts of this previous encrypted version")
+	encryptCmd.Flags().BoolVar(&previousHead, "previous-head", false, "Reuse the key and unchanged ciphertexts of the version in git HEAD")
+	encryptCmd.Flags().BoolVar(&strictProtect, "strict", false, "Fail if a protected key does not match any value or a protected value cannot be encrypted")
+	encryptCmd.Flags().DurationVar(&timeout, "timeout", 0, "Give up if reading or encrypting takes longer than this (e.g. 30s)")
 }
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/agilebits/eh/secrets"
+	"github.com/spf13/cobra"
+)
+
+// protectCmd represents the protect command
+var protectCmd = &cobra.Command{
+	Use:   "protect",
+	Short: "Print the protect list of a Go config type",
+	Long: `This command reads the Go package in the directory and prints the protect list
+for the fields of the type, and of the types inside it, that are tagged with
+eh:"secret" or are secrets.Secret values. The keys of the fields are taken from their hcl, json, yaml or toml
+tags as by secrets.ProtectList.
+
+For example:
+
+  eh protect --type AppConfig ./config
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		if typeName == "" {
+			log.Fatal("missing --type")
+		}
+
+		names, err := protectList(dir, typeName)
+		if err != nil {
+			log.Fatal("failed to generate protect list: ", err)
+		}
+
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			quoted[i] = strconv.Quote(name)
+		}
+
+		fmt.Printf("protect = [%s]\n", strings.Join(quoted, ", "))
+	},
+}
+
+var typeName string
+
+func init() {
+	RootCmd.AddCommand(protectCmd)
+	protectCmd.Flags().StringVar(&typeName, "type", "", "Name of the config struct type")
+}
+
+// protectList returns the keys of the secret fields of the named type in the Go package in dir.
+func protectList(dir string, name string) ([]string, error) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]ast.Expr)
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.TYPE {
+					for _, spec := range gen.Specs {
+						spec := spec.(*ast.TypeSpec)
+						types[spec.Name.Name] = spec.Type
+					}
+				}
+			}
+		}
+	}
+
+	t, ok := types[name]
+	if !ok {
+		return nil, fmt.Errorf("type %s not found in %s", name, dir)
+	}
+
+	names := make(map[string]bool)
+	secretKeys(t, types, map[ast.Expr]bool{}, names)
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// secretKeys adds the keys of the secret fields of the type expression to names. Types from other packages are skipped.
+func secretKeys(expr ast.Expr, types map[string]ast.Expr, visited map[ast.Expr]bool, names map[string]bool) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if named, ok := types[t.Name]; ok && !visited[named] {
+			visited[named] = true
+			secretKeys(named, types, visited, names)
+			delete(visited, named)
+		}
+
+	case *ast.StarExpr:
+		secretKeys(t.X, types, visited, names)
+
+	case *ast.ArrayType:
+		secretKeys(t.Elt, types, visited, names)
+
+	case *ast.MapType:
+		secretKeys(t.Value, types, visited, names)
+
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			var tag reflect.StructTag
+			if field.Tag != nil {
+				unquoted, err := strconv.Unquote(field.Tag.Value)
+				if err == nil {
+					tag = reflect.StructTag(unquoted)
+				}
+			}
+
+			fields := []reflect.StructField{{Name: embeddedName(field.Type), Tag: tag, Anonymous: true}}
+			if len(field.Names) > 0 {
+				fields = fields[:0]
+				for _, name := range field.Names {
+					fields = append(fields, reflect.StructField{Name: name.Name, Tag: tag})
+				}
+			}
+
+			for _, f := range fields {
+				key, secret := secrets.FieldKey(f)
+				if key == "-" {
+					continue
+				}
+
+				// the type of secrets.Secret fields is not known without compiling the package
+				secret = secret || isSecretType(field.Type)
+
+				if secret && key != "" {
+					names[key] = true
+				} else {
+					secretKeys(field.Type, types, visited, names)
+				}
+			}
+		}
+	}
+}
+
+// embeddedName returns the name of an embedded field, which is the name of its type.
+func embeddedName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return embeddedName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+
+	return ""
+}
+
+// isSecretType tells if the type expression is secrets.Secret, or a pointer, slice, array or map of it.
+func isSecretType(expr ast.Expr) bool {
+	switch t := expr.(type) {
+	case *ast.SelectorExpr:
+		pkg, ok := t.X.(*ast.Ident)
+		return ok && pkg.Name == "secrets" && t.Sel.Name == "Secret"
+	case *ast.StarExpr:
+		return isSecretType(t.X)
+	case *ast.ArrayType:
+		return isSecretType(t.Elt)
+	case *ast.MapType:
+		return isSecretType(t.Value)
+	}
+
+	return false
+}
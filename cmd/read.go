@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/agilebits/eh/secrets"
 	"github.com/spf13/cobra"
@@ -13,10 +14,18 @@ var readCmd = &cobra.Command{
 	Use:   "read",
 	Short: "Read and decrypt file and all included fragments",
 	Long: `In addition to decrypting the protected values this command will also read and merge all included files specified in the eh include. 
+
+With --env, or the EH_ENV environment variable, the overlay of the environment
+next to the file, such as app-config.prod.hcl, is merged last.
+
+With --interpolate, ${env:NAME} and ${file:PATH} in string values are replaced
+with environment variables and file contents.
 	
 For example:
 
   eh read app-config.hcl
+  eh read --env prod app-config.hcl
+  eh read --interpolate --strict app-config.hcl
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		url, err := getURL(args)
@@ -24,15 +33,34 @@ For example:
 			log.Fatal("failed to get url: ", err)
 		}
 
-		result, err := secrets.Read(url)
+		if env == "" {
+			env = os.Getenv("EH_ENV")
+		}
+
+		ctx, cancel := newContext()
+		defer cancel()
+
+		result, report, err := secrets.ReadWithReport(ctx, url, secrets.ReadOptions{Env: env, Interpolate: interpolate, Strict: strictInterpolate})
 		if err != nil {
 			log.Fatal("failed to read:", err)
 		}
 
+		for _, conflict := range report.Conflicts {
+			fmt.Fprintf(os.Stderr, "warning: %s is also defined in an included file\n", conflict)
+		}
+
 		fmt.Println(string(result))
 	},
 }
 
+var env string
+var interpolate bool
+var strictInterpolate bool
+
 func init() {
 	RootCmd.AddCommand(readCmd)
+	readCmd.Flags().DurationVar(&timeout, "timeout", 0, "Give up if reading and decrypting takes longer than this (e.g. 30s)")
+	readCmd.Flags().StringVar(&env, "env", "", "Merge the overlay of this environment, such as prod for app-config.prod.hcl (defaults to $EH_ENV)")
+	readCmd.Flags().BoolVar(&interpolate, "interpolate", false, "Replace ${env:NAME} and ${file:PATH} in string values")
+	readCmd.Flags().BoolVar(&strictInterpolate, "strict", false, "Fail if an interpolated environment variable or file does not exist")
 }
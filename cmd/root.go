@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -12,9 +13,9 @@ var cfgFile string
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "eh",
-	Short: "Encrypt and decrypt protected values in .hcl files",
+	Short: "Encrypt and decrypt protected values in config files",
 	Long: `	
-Protect secrets in .hcl files. 
+Protect secrets in .hcl, .json, .yaml, .toml and .env files. 
 	
 This utility relies on the key management system (KMS) provided by the server environment.
 For example, Amazon Web Services KMS is used for servers running on EC2 virtual 
@@ -44,3 +45,4 @@ func Execute() {
 }
 
 var inplace bool
+var timeout time.Duration
@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/agilebits/urlreader"
@@ -57,8 +60,41 @@ func open(url string) (io.Reader, error) {
 	return reader, nil
 }
 
-func read(url string) ([]byte, error) {
+// newContext returns a context that is cancelled after the --timeout duration, if one was given.
+func newContext() (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+func read(ctx context.Context, url string) ([]byte, error) {
+	type result struct {
+		contents []byte
+		err      error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		contents, err := readAll(url)
+		done <- result{contents, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.contents, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func readAll(url string) ([]byte, error) {
 	reader, err := open(url)
+	if err != nil {
+		return nil, err
+	}
+
 	result, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, err
@@ -73,6 +109,20 @@ func read(url string) ([]byte, error) {
 	return result, nil
 }
 
+// readGitHead returns the contents of the file as committed in git HEAD.
+func readGitHead(ctx context.Context, url string) ([]byte, error) {
+	if url == "" || !isFileURL(url) {
+		return nil, errors.New("previous version from git HEAD requires a file name")
+	}
+
+	dir, name := filepath.Split(strings.TrimPrefix(url, "file://"))
+	if dir == "" {
+		dir = "."
+	}
+
+	return exec.CommandContext(ctx, "git", "-C", dir, "show", "HEAD:./"+name).Output()
+}
+
 func write(path string, body []byte) error {
 	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
 	if err != nil {
@@ -1,6 +1,7 @@
 package secrets
 
 import (
+	"context"
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
@@ -67,6 +68,11 @@ func (s *AwsKeyService) setup() error {
 
 // GenerateKey generates a brand new ServerKey.
 func (s *AwsKeyService) GenerateKey(kid string) (*EncryptionKey, error) {
+	return s.GenerateKeyContext(context.Background(), kid)
+}
+
+// GenerateKeyContext generates a brand new ServerKey. The KMS request is cancelled when ctx is done.
+func (s *AwsKeyService) GenerateKeyContext(ctx context.Context, kid string) (*EncryptionKey, error) {
 	if err := s.setup(); err != nil {
 		return nil, errors.Wrapf(err, "failed to setup")
 	}
@@ -78,7 +84,7 @@ func (s *AwsKeyService) GenerateKey(kid string) (*EncryptionKey, error) {
 		KeySpec:           aws.String("AES_256"),
 	}
 
-	out, err := s.service.GenerateDataKey(input)
+	out, err := s.service.GenerateDataKeyWithContext(ctx, input)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to GenerateDataKey")
 	}
@@ -95,6 +101,11 @@ func (s *AwsKeyService) GenerateKey(kid string) (*EncryptionKey, error) {
 
 // DecryptKey decrypts an existing ServerKey.
 func (s *AwsKeyService) DecryptKey(key *EncryptionKey) error {
+	return s.DecryptKeyContext(context.Background(), key)
+}
+
+// DecryptKeyContext decrypts an existing ServerKey. The KMS request is cancelled when ctx is done.
+func (s *AwsKeyService) DecryptKeyContext(ctx context.Context, key *EncryptionKey) error {
 	if err := s.setup(); err != nil {
 		return errors.Wrapf(err, "failed to setup")
 	}
@@ -110,7 +121,7 @@ func (s *AwsKeyService) DecryptKey(key *EncryptionKey) error {
 		GrantTokens:       []*string{aws.String("Encrypt"), aws.String("Decrypt")},
 	}
 
-	out, err := s.service.Decrypt(input)
+	out, err := s.service.DecryptWithContext(ctx, input)
 	if err != nil {
 		return errors.Wrapf(err, "failed to Decrypt")
 	}
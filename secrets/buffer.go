@@ -0,0 +1,31 @@
+package secrets
+
+// Buffer holds decrypted contents. Unlike a string, it can be overwritten in memory with Destroy once the contents is no longer needed.
+//
+// Only the copy in the Buffer is overwritten. Decrypting also leaves the values in strings of the parsed document, which are freed by the garbage collector but not wiped.
+type Buffer struct {
+	b []byte
+}
+
+// Bytes returns the decrypted contents. The slice is overwritten by Destroy and must not be retained.
+func (b *Buffer) Bytes() []byte {
+	return b.b
+}
+
+// Len returns the length of the decrypted contents.
+func (b *Buffer) Len() int {
+	return len(b.b)
+}
+
+// Destroy overwrites the decrypted contents with zeros.
+func (b *Buffer) Destroy() {
+	wipe(b.b)
+	b.b = nil
+}
+
+// wipe overwrites the slice with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,37 @@
+package secrets
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBufferDestroy(t *testing.T) {
+	encrypted, err := Encrypt([]byte(reencryptConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	dir := writeFiles(t, map[string]string{"config.hcl": string(encrypted)})
+	defer os.RemoveAll(dir)
+
+	buf, err := ReadBuffer(filepath.Join(dir, "config.hcl"))
+	if err != nil {
+		t.Fatal("failed to ReadBuffer:", err)
+	}
+
+	contents := buf.Bytes()
+	if !bytes.Contains(contents, []byte(`"db-secret"`)) || buf.Len() != len(contents) {
+		t.Fatalf("expected decrypted contents, got:\n%s", contents)
+	}
+
+	buf.Destroy()
+	if buf.Bytes() != nil || buf.Len() != 0 {
+		t.Error("expected Buffer to be empty after Destroy")
+	}
+
+	if !bytes.Equal(contents, make([]byte, len(contents))) {
+		t.Errorf("expected contents to be overwritten with zeros, got:\n%s", contents)
+	}
+}
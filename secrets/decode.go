@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DecodeError is returned by DecodableDocument.Decode if the decoder tells which value cannot be decoded.
+type DecodeError struct {
+	// Path is the dotted path of the value, as returned by Value.Path.
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %q: %v", e.Path, e.Err)
+}
+
+// Decode reads the url like ReadWithOptions, and decodes the decrypted and merged contents into out, which must be a pointer, without formatting and parsing it again. Only the first options are used.
+//
+// The contents is decoded with the decoder of its format, so out uses its tags: `hcl` tags of github.com/hashicorp/hcl for HCL, gohcl tags for HCL2, `json` tags for JSON and dotenv, whose variables are strings, and `yaml` and `toml` tags for YAML and TOML. Errors tell the url and, if the decoder reports it, the path of the value and the included file or overlay that it came from.
+func Decode(url string, out interface{}, options ...ReadOptions) error {
+	return DecodeContext(context.Background(), url, out, options...)
+}
+
+// DecodeContext is like Decode but gives up waiting for the url fetches and the key service when ctx is done.
+func DecodeContext(ctx context.Context, url string, out interface{}, options ...ReadOptions) error {
+	var opts ReadOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	result, err := readIncludes(ctx, url, opts, true)
+	if err != nil {
+		return err
+	}
+	defer result.wipe()
+
+	if len(result.parts) > 0 {
+		return fmt.Errorf("failed to decode url %q: included %s contents cannot be merged", url, result.format)
+	}
+
+	doc, ok := result.doc.(DecodableDocument)
+	if !ok {
+		return fmt.Errorf("failed to decode url %q: %s contents cannot be decoded", url, result.format)
+	}
+
+	if err := doc.Decode(out); err != nil {
+		if decodeErr, ok := err.(*DecodeError); ok {
+			if source := result.sourceOf(decodeErr.Path); source != "" {
+				return errors.Wrapf(err, "failed to decode url %q with values from %q", url, source)
+			}
+		}
+		return errors.Wrapf(err, "failed to decode url %q", url)
+	}
+
+	return nil
+}
+
+// sourceOf returns the included file or overlay that the merged value at the path is taken from, or "" if it is in the url itself or cannot be told.
+func (r *included) sourceOf(path string) string {
+	merged := valuesAt(r.doc, path)
+	if len(merged) == 0 {
+		return ""
+	}
+
+	for i, source := range r.sources {
+		if reflect.DeepEqual(valuesAt(source.doc, path), merged) {
+			if i == 0 {
+				return ""
+			}
+			return source.url
+		}
+	}
+
+	return ""
+}
+
+// valuesAt returns the types and texts of the values of the document at the path, which is matched without case, as by the decoders.
+func valuesAt(doc Document, path string) []string {
+	var result []string
+	doc.Walk(func(v Value) error {
+		if !strings.EqualFold(v.Path(), path) {
+			return nil
+		}
+
+		// the values inside are compared with the container
+		if text, valueType, err := v.Get(); err == nil {
+			result = append(result, valueType+":"+text)
+		}
+		return SkipValue
+	})
+
+	return result
+}
@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	type db struct {
+		Host     string `hcl:"host" json:"host" yaml:"host" toml:"host"`
+		Port     int    `hcl:"port" json:"port" yaml:"port" toml:"port"`
+		Password string `hcl:"password" json:"password" yaml:"password" toml:"password"`
+	}
+
+	type config struct {
+		DB db `hcl:"db" json:"db" yaml:"db" toml:"db"`
+	}
+
+	type hcl2Config struct {
+		DB struct {
+			Host     string `hcl:"host,attr"`
+			Port     int    `hcl:"port,attr"`
+			Password string `hcl:"password,attr"`
+		} `hcl:"db,block"`
+	}
+
+	type dotenvConfig struct {
+		Host     string `json:"DB_HOST"`
+		Password string `json:"DB_PASSWORD"`
+	}
+
+	encrypted, err := Encrypt([]byte("eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n\tprotect = [\"password\"]\n\tinclude = [\"./shared.hcl\"]\n}\n\ndb {\n\thost = \"localhost\"\n\tpassword = \"secret\"\n}\n"))
+	if err != nil {
+		t.Fatalf("failed to Encrypt: %v", err)
+	}
+
+	dir := writeFiles(t, map[string]string{
+		"config.hcl":  string(encrypted),
+		"shared.hcl":  "eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n}\n\ndb {\n\tport = 5432\n}\n",
+		"config.tf":   "eh {\n  encrypted = false\n  key = \"\"\n  syntax = \"hcl2\"\n  service {\n    type = \"local\"\n  }\n}\n\ndb {\n  host = \"localhost\"\n  port = 5432\n  password = \"secret\"\n}\n",
+		"config.json": `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}}, "db": {"host": "localhost", "port": 5432, "password": "secret"}}`,
+		"config.yaml": "eh:\n  encrypted: false\n  key: \"\"\n  service:\n    type: local\ndb:\n  host: localhost\n  port: 5432\n  password: secret\n",
+		"config.toml": "[eh]\nencrypted = false\nkey = \"\"\n[eh.service]\ntype = \"local\"\n\n[db]\nhost = \"localhost\"\nport = 5432\npassword = \"secret\"\n",
+		"config.env":  "#eh encrypted = false\n#eh key = \"\"\n#eh service { type = \"local\" }\nDB_HOST=localhost\nDB_PASSWORD=\"secret\"\n",
+		"wrong.json":  `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}}, "db": {"port": "5432"}}`,
+		"wrong.toml":  "[eh]\nencrypted = false\nkey = \"\"\n[eh.service]\ntype = \"local\"\n\n[db]\nport = \"5432\"\n",
+
+		"include-wrong.json": `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}, "include": ["./wrong.json"]}, "db": {"host": "localhost"}}`,
+		"config.wrong.json":  `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}}, "db": {"port": "5432"}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	expected := db{Host: "localhost", Port: 5432, Password: "secret"}
+	for _, name := range []string{"config.hcl", "config.json", "config.yaml", "config.toml"} {
+		var cfg config
+		if err := Decode(filepath.Join(dir, name), &cfg); err != nil {
+			t.Errorf("failed to Decode %s: %v", name, err)
+		} else if cfg.DB != expected {
+			t.Errorf("unexpected values of %s: %+v", name, cfg.DB)
+		}
+	}
+
+	var h hcl2Config
+	if err := Decode(filepath.Join(dir, "config.tf"), &h); err != nil {
+		t.Errorf("failed to Decode config.tf: %v", err)
+	} else if h.DB.Host != "localhost" || h.DB.Port != 5432 || h.DB.Password != "secret" {
+		t.Errorf("unexpected values of config.tf: %+v", h.DB)
+	}
+
+	var e dotenvConfig
+	if err := Decode(filepath.Join(dir, "config.env"), &e); err != nil {
+		t.Errorf("failed to Decode config.env: %v", err)
+	} else if e.Host != "localhost" || e.Password != "secret" {
+		t.Errorf("unexpected values of config.env: %+v", e)
+	}
+
+	for _, name := range []string{"wrong.json", "wrong.toml"} {
+		var cfg config
+		err := Decode(filepath.Join(dir, name), &cfg)
+		if err == nil || !strings.Contains(err.Error(), name) || !strings.Contains(strings.ToLower(err.Error()), "port") {
+			t.Errorf("expected error with url and path of %s, got %v", name, err)
+		}
+	}
+
+	var cfg config
+	err = Decode(filepath.Join(dir, "include-wrong.json"), &cfg)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "wrong.json")) {
+		t.Errorf("expected error with the included url, got %v", err)
+	}
+
+	err = Decode(filepath.Join(dir, "config.json"), &cfg, ReadOptions{Env: "wrong"})
+	if err == nil || !strings.Contains(err.Error(), "config.wrong.json") {
+		t.Errorf("expected error with the url of the overlay, got %v", err)
+	}
+}
+
+func TestDecodeNamesTheFileOfAValue(t *testing.T) {
+	type hcl2Config struct {
+		DB struct {
+			Host     string `hcl:"host,attr"`
+			Port     int    `hcl:"port,attr"`
+			Password string `hcl:"password,attr"`
+		} `hcl:"db,block"`
+	}
+
+	type yamlConfig struct {
+		DB struct {
+			Host string `yaml:"host"`
+			Port int    `yaml:"port"`
+		} `yaml:"db"`
+	}
+
+	header := "eh {\n  encrypted = false\n  key = \"\"\n  syntax = \"hcl2\"\n  service {\n    type = \"local\"\n  }\n  include = [\"./INCLUDE\"]\n}\n\n"
+	dir := writeFiles(t, map[string]string{
+		// partial.tf cannot be decoded on its own, but it is not the file of the wrong port
+		"main.tf":    strings.Replace(header, "INCLUDE", "partial.tf", 1) + "db {\n  host = \"localhost\"\n  port = \"abc\"\n}\n",
+		"partial.tf": strings.Replace(header, "  include = [\"./INCLUDE\"]\n", "", 1) + "db {\n  password = \"secret\"\n}\n",
+		"other.tf":   strings.Replace(header, "INCLUDE", "port.tf", 1) + "db {\n  host = \"localhost\"\n  password = \"secret\"\n}\n",
+		"port.tf":    strings.Replace(header, "  include = [\"./INCLUDE\"]\n", "", 1) + "db {\n  port = \"abc\"\n}\n",
+		"main.yaml":  "eh:\n  encrypted: false\n  key: \"\"\n  service:\n    type: local\n  include: [./port.yaml]\ndb:\n  host: localhost\n",
+		"port.yaml":  "eh:\n  encrypted: false\n  key: \"\"\n  service:\n    type: local\ndb:\n  port: abc\n",
+	})
+	defer os.RemoveAll(dir)
+
+	var h hcl2Config
+	err := Decode(filepath.Join(dir, "main.tf"), &h)
+	if err == nil || strings.Contains(err.Error(), "partial.tf") || !strings.Contains(err.Error(), `"db.port"`) {
+		t.Errorf("expected error with the path of the port in main.tf, got %v", err)
+	}
+
+	err = Decode(filepath.Join(dir, "other.tf"), &h)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "port.tf")) {
+		t.Errorf("expected error with the url of port.tf, got %v", err)
+	}
+
+	var y yamlConfig
+	err = Decode(filepath.Join(dir, "main.yaml"), &y)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "port.yaml")) || !strings.Contains(err.Error(), `"db.port"`) {
+		t.Errorf("expected error with the url of port.yaml, got %v", err)
+	}
+}
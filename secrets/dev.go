@@ -1,6 +1,7 @@
 package secrets
 
 import (
+	"context"
 	"crypto/rand"
 	"io/ioutil"
 	"log"
@@ -69,6 +70,8 @@ func readKey(kid string) (*EncryptionKey, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "readKey failed to ReadFile")
 	}
+	// the master key file contains the raw key
+	defer wipe(buf)
 
 	key := &EncryptionKey{}
 	if err := json.Unmarshal(buf, &key); err != nil {
@@ -83,6 +86,7 @@ func writeKey(key *EncryptionKey) error {
 	if err != nil {
 		return errors.Wrap(err, "writeKey failed to Marshal")
 	}
+	defer wipe(buf)
 
 	if err := ioutil.WriteFile(filepathForKeyID(key.KID), buf, 0700); err != nil {
 		return errors.Wrap(err, "writeKey failed to WriteFile")
@@ -156,3 +160,21 @@ func (s *DevKeyService) DecryptKey(key *EncryptionKey) error {
 
 	return nil
 }
+
+// GenerateKeyContext generates a new server key unless ctx is already done. The dev key service does not wait for anything, so ctx is only checked before starting.
+func (s *DevKeyService) GenerateKeyContext(ctx context.Context, kid string) (*EncryptionKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.GenerateKey(kid)
+}
+
+// DecryptKeyContext decrypts the dev key unless ctx is already done. Like GenerateKeyContext, it checks ctx only before starting.
+func (s *DevKeyService) DecryptKeyContext(ctx context.Context, key *EncryptionKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.DecryptKey(key)
+}
@@ -64,3 +64,61 @@ func TestCanEncryptAndDecrypt(t *testing.T) {
 		t.Errorf("encrypt/decrypt failed, expected %q but got %q", source, plaintext)
 	}
 }
+
+func TestCanDecryptMessagesOfEveryCipher(t *testing.T) {
+	svc := NewDevKeyService()
+
+	key, err := svc.GenerateKey("somekey")
+	if err != nil {
+		t.Fatal("failed to generate key:", err)
+	}
+
+	source := "Hello, World!"
+	var messages [][]byte
+	for _, enc := range []string{A256GCM, C20P, XC20P} {
+		key.Enc = enc
+		ciphertext, err := key.Encrypt([]byte(source))
+		if err != nil {
+			t.Fatalf("failed to Encrypt with %s: %v", enc, err)
+		}
+		messages = append(messages, ciphertext)
+	}
+
+	// the same key must decrypt messages of every cipher regardless of its own Enc
+	for _, message := range messages {
+		plaintext, err := key.Decrypt(message)
+		if err != nil {
+			t.Fatal("failed to Decrypt:", err)
+		}
+
+		if string(plaintext) != source {
+			t.Errorf("encrypt/decrypt failed, expected %q but got %q", source, plaintext)
+		}
+	}
+}
+
+func TestDestroyWipesRawKey(t *testing.T) {
+	svc := NewDevKeyService()
+
+	key, err := svc.GenerateKey("somekey")
+	if err != nil {
+		t.Fatal("failed to generate key:", err)
+	}
+
+	rawKey := key.RawKey
+	key.Destroy()
+
+	if key.RawKey != nil {
+		t.Error("expected RawKey to be nil after Destroy")
+	}
+
+	for _, b := range rawKey {
+		if b != 0 {
+			t.Fatal("expected RawKey to be overwritten with zeros")
+		}
+	}
+
+	if _, err := key.Encrypt([]byte("Hello, World!")); err == nil {
+		t.Error("expected Encrypt to fail after Destroy")
+	}
+}
@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"bytes"
+	neturl "net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Format is a config file syntax that can hold protected values.
+type Format interface {
+	// Name identifies the format, for example "json". Only contents of the same format can be included.
+	Name() string
+
+	// Detect tells if the contents is in this format.
+	Detect(contents []byte) bool
+
+	// Parse parses the contents.
+	Parse(contents []byte) (Document, error)
+}
+
+// ExtensionFormat is a Format that is selected by the extension of file names, such as ".json".
+type ExtensionFormat interface {
+	Format
+
+	// Extensions returns the lowercase file name extensions of the format, with the dot.
+	Extensions() []string
+}
+
+// Document is a parsed config file with an 'eh' header.
+type Document interface {
+	// Header returns the decoded 'eh' header.
+	Header() (*Header, error)
+
+	// SetHeaderValue replaces the string "key" or the bool "encrypted" in the 'eh' header.
+	SetHeaderValue(name string, value interface{}) error
+
+	// RemoveHeader removes the 'eh' header.
+	RemoveHeader() error
+
+	// Walk calls fn for every value outside of the header, containers first.
+	// The values inside a container are skipped if fn returns SkipValue.
+	Walk(fn func(Value) error) error
+
+	// Bytes returns the text of the document with all changes.
+	Bytes() ([]byte, error)
+}
+
+// Value is a value in a Document that can be protected.
+type Value interface {
+	// Names are the keys from the top of the document down to the value.
+	Names() []string
+
+	// Path is the dotted path that the ciphertext of the value is bound to.
+	Path() string
+
+	// Get returns the text of the value, unquoted for strings, and its type.
+	// It fails with an UnsupportedError if the value cannot be protected.
+	Get() (string, string, error)
+
+	// Set replaces the value with text of the type.
+	Set(text string, valueType string) error
+}
+
+// AnnotatedValue is a value that can be marked as protected in the file, such as with an eh:protect comment.
+type AnnotatedValue interface {
+	Value
+
+	// Annotated tells if the value is marked as protected.
+	Annotated() bool
+}
+
+// LabeledValue is a value inside blocks with labels, such as `service "db" { ... }` in HCL. The labels are not names, so they cannot be protected, but they are decoded as map keys.
+type LabeledValue interface {
+	Value
+
+	// Keys are the names and the labels from the top of the document down to the value.
+	Keys() []string
+}
+
+// RawValue is a string value whose text in the file cannot always be written again from the string, such as a dotenv value with line breaks.
+type RawValue interface {
+	Value
+
+	// Raw returns the text of the value with its quotes and the type that Set restores it with.
+	// It returns false if Set writes the same text for the string.
+	Raw() (string, string, bool)
+}
+
+// protectAnnotation marks a value as protected when it is the text of a comment.
+const protectAnnotation = "eh:protect"
+
+// MergeableDocument is a document that included fragments can be merged into.
+type MergeableDocument interface {
+	Document
+
+	// Merge merges the fragment, which has no header, into the document deeply.
+	// Other values in both are replaced if resolve returns true for their path.
+	Merge(fragment Document, resolve func(path string) bool) error
+}
+
+// DecodableDocument is a document that can be decoded into a Go value with the decoder of its format.
+type DecodableDocument interface {
+	Document
+
+	// Decode decodes the document without its header into out, which must be a pointer.
+	// It returns a DecodeError if the decoder tells which value cannot be decoded.
+	Decode(out interface{}) error
+}
+
+// errNotMergeable is returned by Merge for fragments it cannot merge, such as HCL2 in HCL.
+var errNotMergeable = errors.New("fragment cannot be merged")
+
+// Types of values. Formats can add their own lowercase types.
+const (
+	ValueString = ""
+	ValueNumber = "number"
+	ValueFloat  = "float"
+	ValueBool   = "bool"
+)
+
+// SkipValue is returned by the function passed to Document.Walk to skip the values inside the current one.
+var SkipValue = errors.New("skip this value")
+
+// UnsupportedError is returned by Value.Get for values that cannot be protected.
+type UnsupportedError struct {
+	Reason string
+}
+
+func (e *UnsupportedError) Error() string {
+	return e.Reason
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   []Format
+)
+
+// RegisterFormat adds a format, which is detected before the ones registered earlier and HCL.
+func RegisterFormat(format Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	formats = append([]Format{format}, formats...)
+}
+
+func init() {
+	for _, format := range []Format{hclFormat{}, dotenvFormat{}, tomlFormat{}, yamlFormat{}, jsonFormat{}} {
+		RegisterFormat(format)
+	}
+}
+
+// detectFormat returns the format for the extension of the name. If no format has the extension, it returns the most recently registered format that accepts the contents.
+func detectFormat(name string, contents []byte) Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	if ext := nameExt(name); ext != "" {
+		for _, format := range formats {
+			if f, ok := format.(ExtensionFormat); ok && hasExtension(f, ext) {
+				return format
+			}
+		}
+	}
+
+	for _, format := range formats {
+		if format.Detect(contents) {
+			return format
+		}
+	}
+
+	return hclFormat{}
+}
+
+// nameExt returns the lowercase extension of the file name or url, without its query.
+func nameExt(name string) string {
+	if u, err := neturl.Parse(name); err == nil && u.Scheme != "" {
+		name = u.Path
+	}
+
+	return strings.ToLower(path.Ext(filepath.ToSlash(name)))
+}
+
+func hasExtension(format ExtensionFormat, ext string) bool {
+	for _, e := range format.Extensions() {
+		if e == ext {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseDocument finds the format of the contents from the name or the contents, parses it and decodes its 'eh' header.
+func parseDocument(name string, contents []byte) (Format, Document, *Header, error) {
+	format := detectFormat(name, contents)
+
+	doc, err := format.Parse(contents)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	header, err := doc.Header()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return format, doc, header, nil
+}
+
+// textEdit replaces the text between start and end.
+type textEdit struct {
+	start, end int
+	text       []byte
+}
+
+// applyEdits returns the text with the edits, which must not overlap, applied.
+func applyEdits(text []byte, edits []textEdit) ([]byte, error) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	var result bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if e.start < last {
+			return nil, errors.New("failed, overlapping changes")
+		}
+
+		result.Write(text[last:e.start])
+		result.Write(e.text)
+		last = e.end
+	}
+	result.Write(text[last:])
+
+	return result.Bytes(), nil
+}
+
+// removeEdits returns the removals followed by the edits that are not inside one of them.
+func removeEdits(edits []textEdit, removals []textEdit) []textEdit {
+	result := append([]textEdit(nil), removals...)
+	for _, e := range edits {
+		removed := false
+		for _, r := range removals {
+			if e.start >= r.start && e.end <= r.end {
+				removed = true
+			}
+		}
+
+		if !removed {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,177 @@
+package secrets
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// linesFormat is a minimal format with name=value lines. It is detected by its first line and has header lines such as "eh.protect=password".
+type linesFormat struct{}
+
+func (linesFormat) Name() string {
+	return "lines"
+}
+
+func (linesFormat) Detect(contents []byte) bool {
+	return strings.HasPrefix(string(contents), "#lines\n")
+}
+
+func (linesFormat) Parse(contents []byte) (Document, error) {
+	d := &linesDocument{header: &Header{}}
+	for _, line := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		name, value := line, ""
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name, value = line[:i], line[i+1:]
+		}
+
+		d.lines = append(d.lines, &linesValue{name: name, value: value})
+
+		switch name {
+		case "eh.encrypted":
+			d.header.Encrypted = value == "true"
+		case "eh.key":
+			d.header.Key = value
+		case "eh.service":
+			d.header.Service.Type = value
+		case "eh.protect":
+			d.header.Protect = strings.Split(value, ",")
+		}
+	}
+
+	return d, nil
+}
+
+type linesDocument struct {
+	header *Header
+	lines  []*linesValue
+}
+
+func (d *linesDocument) Header() (*Header, error) {
+	return d.header, nil
+}
+
+func (d *linesDocument) SetHeaderValue(name string, value interface{}) error {
+	for _, line := range d.lines {
+		if line.name == "eh."+name {
+			line.value = fmt.Sprint(value)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no %q line", name)
+}
+
+func (d *linesDocument) RemoveHeader() error {
+	var lines []*linesValue
+	for _, line := range d.lines {
+		if !strings.HasPrefix(line.name, "eh.") {
+			lines = append(lines, line)
+		}
+	}
+	d.lines = lines
+
+	return nil
+}
+
+func (d *linesDocument) Walk(fn func(Value) error) error {
+	for _, line := range d.lines {
+		if strings.HasPrefix(line.name, "#") || strings.HasPrefix(line.name, "eh.") {
+			continue
+		}
+
+		if err := fn(line); err != nil && err != SkipValue {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *linesDocument) Bytes() ([]byte, error) {
+	var b strings.Builder
+	for _, line := range d.lines {
+		b.WriteString(line.name)
+		if !strings.HasPrefix(line.name, "#") {
+			b.WriteString("=" + line.value)
+		}
+		b.WriteString("\n")
+	}
+
+	return []byte(b.String()), nil
+}
+
+type linesValue struct {
+	name, value string
+}
+
+func (v *linesValue) Names() []string {
+	return []string{v.name}
+}
+
+func (v *linesValue) Path() string {
+	return v.name
+}
+
+func (v *linesValue) Get() (string, string, error) {
+	if _, err := strconv.Atoi(v.value); err == nil {
+		return v.value, ValueNumber, nil
+	}
+
+	return v.value, ValueString, nil
+}
+
+func (v *linesValue) Set(text string, valueType string) error {
+	v.value = text
+	return nil
+}
+
+func TestRegisterFormat(t *testing.T) {
+	RegisterFormat(linesFormat{})
+
+	contents := "#lines\neh.encrypted=false\neh.key=\neh.service=local\neh.protect=password,pin\nuser=admin\npassword=secret\npin=1234\n"
+
+	encrypted, err := Encrypt([]byte(contents))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{"secret", "1234"} {
+		if strings.Contains(string(encrypted), plaintext) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	if !strings.Contains(string(encrypted), "\npin=eh:number:") || !strings.Contains(string(encrypted), "\nuser=admin\n") {
+		t.Errorf("unexpected encrypted contents:\n%s", encrypted)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if string(decrypted) != contents {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
+
+func TestDetectFormatByExtension(t *testing.T) {
+	hclWithComment := "#eh comment that looks like a dotenv header\neh {\n\tencrypted = false\n}\n"
+	for _, test := range []struct {
+		name, contents, expected string
+	}{
+		{"config.hcl", hclWithComment, formatHCL},
+		{"https://example.com/config.HCL?version=2", hclWithComment, formatHCL},
+		{"", hclWithComment, formatDotenv},
+		{"config.local", hclWithComment, formatDotenv},
+		{".env", "#eh encrypted = false\n", formatDotenv},
+		{"config.yml", "eh:\n  encrypted: false\n", formatYAML},
+		{"config.json", "{}", formatJSON},
+	} {
+		if format := detectFormat(test.name, []byte(test.contents)); format.Name() != test.expected {
+			t.Errorf("expected %q to be %s, got %s", test.name, test.expected, format.Name())
+		}
+	}
+}
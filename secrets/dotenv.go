@@ -0,0 +1,377 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/pkg/errors"
+)
+
+const formatDotenv = "dotenv"
+
+// Protected values that cannot be written again from their string are encrypted as their text, with quotes.
+const valueTypeDotenv = "dotenv"
+
+// dotenvHeaderPrefix starts the comment lines with the body of the 'eh' header, in HCL:
+//
+//	#eh encrypted = false
+//	#eh key = ""
+//	#eh service { type = "local" }
+//	#eh protect = ["DB_PASSWORD"]
+const dotenvHeaderPrefix = "#eh "
+
+// dotenvDocument is a document with KEY=value lines, edited in the original text.
+type dotenvDocument struct {
+	text   []byte
+	header *Header
+	values []*dotenvValue
+
+	// lines are the positions of the header lines, including their newline
+	lines []textEdit
+	edits []textEdit
+}
+
+// dotenvValue is the value of a variable and its position in the text, including quotes.
+type dotenvValue struct {
+	doc        *dotenvDocument
+	name       string
+	start, end int
+	quote      byte
+	value      string
+}
+
+// dotenvFormat is the dotenv format. It accepts contents with header lines.
+type dotenvFormat struct{}
+
+func (dotenvFormat) Name() string {
+	return formatDotenv
+}
+
+func (dotenvFormat) Extensions() []string {
+	return []string{".env"}
+}
+
+func (dotenvFormat) Detect(contents []byte) bool {
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, dotenvHeaderPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse parses the KEY=value lines and decodes the header lines.
+func (dotenvFormat) Parse(contents []byte) (Document, error) {
+	return parseDotenv(contents)
+}
+
+func parseDotenv(contents []byte) (*dotenvDocument, error) {
+	// the document keeps its own copy because the contents may be wiped after parsing
+	d := &dotenvDocument{text: append([]byte(nil), contents...)}
+
+	var header bytes.Buffer
+	header.WriteString("eh {\n")
+
+	for pos := 0; pos < len(d.text); {
+		end := lineEnd(d.text, pos)
+		line := string(d.text[pos:end])
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, dotenvHeaderPrefix):
+			header.WriteString(strings.TrimPrefix(line, dotenvHeaderPrefix))
+			header.WriteByte('\n')
+			d.lines = append(d.lines, textEdit{start: pos, end: nextLine(d.text, end)})
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		default:
+			v, valueEnd, err := parseDotenvLine(d.text, pos)
+			if err != nil {
+				return nil, err
+			}
+			v.doc = d
+			d.values = append(d.values, v)
+			end = lineEnd(d.text, valueEnd)
+		}
+
+		pos = nextLine(d.text, end)
+	}
+
+	header.WriteString("}\n")
+
+	tree, err := hcl.Parse(header.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode header lines")
+	}
+
+	d.header, err = decodeHeader(tree)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode header lines")
+	}
+	return d, nil
+}
+
+// parseDotenvLine parses the variable on the line at pos and returns the position after its value.
+func parseDotenvLine(text []byte, pos int) (*dotenvValue, int, error) {
+	end := lineEnd(text, pos)
+	line := string(text[pos:end])
+
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return nil, 0, fmt.Errorf("failed, invalid line %q", line)
+	}
+
+	v := &dotenvValue{name: strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[:eq]), "export "))}
+
+	v.start = pos + eq + 1
+	for v.start < end && (text[v.start] == ' ' || text[v.start] == '\t') {
+		v.start++
+	}
+
+	if v.start < len(text) && (text[v.start] == '"' || text[v.start] == '\'') {
+		v.quote = text[v.start]
+		i := v.start + 1
+		for ; i < len(text) && text[i] != v.quote; i++ {
+			if text[i] == '\\' && v.quote == '"' {
+				i++
+			}
+		}
+
+		if i >= len(text) {
+			return nil, 0, fmt.Errorf("failed, unterminated value of %q", v.name)
+		}
+
+		v.end = i + 1
+		v.value = string(text[v.start+1 : i])
+		if v.quote == '"' {
+			v.value = unescapeDotenv(v.value)
+		}
+
+		return v, v.end, nil
+	}
+
+	v.end = end
+	if comment := strings.Index(string(text[v.start:end]), " #"); comment >= 0 {
+		v.end = v.start + comment
+	}
+	for v.end > v.start && (text[v.end-1] == ' ' || text[v.end-1] == '\t' || text[v.end-1] == '\r') {
+		v.end--
+	}
+
+	v.value = string(text[v.start:v.end])
+	return v, v.end, nil
+}
+
+func lineEnd(text []byte, pos int) int {
+	if i := bytes.IndexByte(text[pos:], '\n'); i >= 0 {
+		return pos + i
+	}
+
+	return len(text)
+}
+
+func nextLine(text []byte, end int) int {
+	if end < len(text) {
+		return end + 1
+	}
+
+	return end
+}
+
+func unescapeDotenv(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+// quoteDotenv returns the value in the same quotes as before if possible, or in double quotes.
+func quoteDotenv(s string, quote byte) []byte {
+	switch {
+	case quote == '\'' && !strings.Contains(s, "'"):
+		return []byte("'" + s + "'")
+	case quote == 0 && isBareDotenv(s):
+		return []byte(s)
+	default:
+		return []byte(`"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + `"`)
+	}
+}
+
+// isBareDotenv tells if the value is read back the same without quotes.
+func isBareDotenv(s string) bool {
+	return strings.TrimSpace(s) == s && !strings.ContainsAny(s, "\r\n") && !strings.Contains(s, " #") &&
+		!strings.HasPrefix(s, "'") && !strings.HasPrefix(s, `"`)
+}
+
+func (d *dotenvDocument) Header() (*Header, error) {
+	return d.header, nil
+}
+
+func (d *dotenvDocument) Walk(fn func(Value) error) error {
+	for _, v := range d.values {
+		if err := fn(v); err != nil && err != SkipValue {
+			return errors.Wrapf(err, "failed to process %q", v.name)
+		}
+	}
+
+	return nil
+}
+
+func (v *dotenvValue) Names() []string {
+	return []string{v.name}
+}
+
+func (v *dotenvValue) Path() string {
+	return v.name
+}
+
+// Get returns the value as a string, dotenv files have no other types.
+func (v *dotenvValue) Get() (string, string, error) {
+	return v.value, ValueString, nil
+}
+
+// Raw returns the text of the value if it is not written the same way again, such as a double-quoted value with line breaks.
+func (v *dotenvValue) Raw() (string, string, bool) {
+	raw := v.doc.text[v.start:v.end]
+	if bytes.Equal(quoteDotenv(v.value, v.quote), raw) {
+		return "", "", false
+	}
+
+	return string(raw), valueTypeDotenv, true
+}
+
+func (v *dotenvValue) Set(text string, valueType string) error {
+	switch valueType {
+	case ValueString:
+		v.doc.edits = append(v.doc.edits, textEdit{start: v.start, end: v.end, text: quoteDotenv(text, v.quote)})
+	case valueTypeDotenv:
+		v.doc.edits = append(v.doc.edits, textEdit{start: v.start, end: v.end, text: []byte(text)})
+	default:
+		return fmt.Errorf("failed, unknown type %q of %q", valueType, v.name)
+	}
+
+	return nil
+}
+
+// SetHeaderValue replaces the header line of the named value.
+func (d *dotenvDocument) SetHeaderValue(name string, value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = strconv.Quote(v)
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return fmt.Errorf("failed, unsupported value of %q: %v", name, value)
+	}
+
+	for _, line := range d.lines {
+		fields := strings.Fields(strings.TrimPrefix(string(d.text[line.start:line.end]), dotenvHeaderPrefix))
+		if len(fields) > 0 && strings.TrimSuffix(fields[0], "=") == name {
+			d.edits = append(d.edits, textEdit{start: line.start, end: line.end, text: []byte(dotenvHeaderPrefix + name + " = " + text + "\n")})
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed, no %q element found in 'eh'", name)
+}
+
+// RemoveHeader removes the header lines. Earlier changes to the header are removed with them.
+func (d *dotenvDocument) RemoveHeader() error {
+	d.edits = removeEdits(d.edits, d.lines)
+	return nil
+}
+
+// Merge merges the variables of the fragment into the document. New variables are added at the end.
+func (d *dotenvDocument) Merge(fragment Document, resolve func(path string) bool) error {
+	f, ok := fragment.(*dotenvDocument)
+	if !ok {
+		return errNotMergeable
+	}
+
+	text, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(text)
+
+	fragmentText, err := f.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(fragmentText)
+
+	// both are parsed again from their text, so that earlier changes such as decrypted values are included
+	merged, err := parseDotenv(text)
+	if err != nil {
+		return err
+	}
+
+	parsedFragment, err := parseDotenv(fragmentText)
+	if err != nil {
+		return err
+	}
+
+	end := len(merged.text)
+	if end > 0 && merged.text[end-1] != '\n' {
+		merged.edits = append(merged.edits, textEdit{start: end, end: end, text: []byte("\n")})
+	}
+
+	for _, v := range parsedFragment.values {
+		var existing *dotenvValue
+		for _, e := range merged.values {
+			if e.name == v.name {
+				existing = e
+			}
+		}
+
+		switch {
+		case existing == nil:
+			line := append([]byte(v.name+"="), parsedFragment.text[v.start:v.end]...)
+			merged.edits = append(merged.edits, textEdit{start: end, end: end, text: append(line, '\n')})
+		case existing.value != v.value && resolve(v.name):
+			if err := existing.Set(v.value, ValueString); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.text, d.values, d.lines, d.edits = merged.text, merged.values, merged.lines, merged.edits
+	for _, v := range d.values {
+		v.doc = d
+	}
+
+	return nil
+}
+
+func (d *dotenvDocument) Bytes() ([]byte, error) {
+	return applyEdits(d.text, d.edits)
+}
+
+// Decode parses the text again and decodes the variables like a JSON object of strings.
+func (d *dotenvDocument) Decode(out interface{}) error {
+	text, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(text)
+
+	doc, err := parseDotenv(text)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]string, len(doc.values))
+	for _, v := range doc.values {
+		values[v.name] = v.value
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	defer wipe(encoded)
+
+	return decodeJSON(encoded, out)
+}
@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+const dotenvConfig = `#eh encrypted = false
+#eh key = ""
+#eh service { type = "local" }
+#eh protect = ["DB_PASSWORD", "API_TOKEN", "GREETING"]
+
+# database
+DB_HOST=db.example.com
+DB_PASSWORD="correct horse" # rotated monthly
+export API_TOKEN=abc123
+GREETING='hello world'
+`
+
+func TestDotenvRoundTrip(t *testing.T) {
+	encrypted, err := Encrypt([]byte(dotenvConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{"correct horse", "abc123", "hello world"} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	for _, kept := range []string{"# database", "DB_HOST=db.example.com", "# rotated monthly", "export API_TOKEN=", "#eh encrypted = true"} {
+		if !bytes.Contains(encrypted, []byte(kept)) {
+			t.Errorf("expected %q to be kept, got:\n%s", kept, encrypted)
+		}
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if string(decrypted) != dotenvConfig {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
+
+func TestDotenvRestoresQuotes(t *testing.T) {
+	config := `#eh encrypted = false
+#eh key = ""
+#eh service { type = "local" }
+#eh protect = ["EMPTY", "HASH", "CERT", "ESCAPED", "QUOTED"]
+
+EMPTY=
+HASH=a#b
+CERT="-----BEGIN-----
+MIIB
+-----END-----"
+ESCAPED="a\nb"
+QUOTED='it is "quoted"'
+`
+
+	encrypted, err := Encrypt([]byte(config))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{"a#b", "MIIB", `a\nb`, "quoted"} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if string(decrypted) != config {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
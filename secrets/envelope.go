@@ -0,0 +1,115 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The compact envelope is an alternative to the JSON ciphertext. It is encoded as
+//
+//	eh:v2:base64url(version || enc || key reference || nonce || ciphertext)
+//
+// where version and enc are single bytes and the key reference is the first bytes of the SHA-256 hash of the KID.
+const (
+	compactPrefix  = "eh:v2:"
+	compactVersion = 2
+	keyRefSize     = 4
+)
+
+var encIDs = map[string]byte{
+	A256GCM: 1,
+	C20P:    2,
+	XC20P:   3,
+	A256SIV: 4,
+}
+
+func isCompact(value string) bool {
+	return strings.HasPrefix(value, compactPrefix)
+}
+
+func keyRef(kid string) []byte {
+	sum := sha256.Sum256([]byte(kid))
+	return sum[:keyRefSize]
+}
+
+// sealCompact encrypts the plaintext and returns it in the compact envelope.
+func (key *EncryptionKey) sealCompact(plaintext []byte, additionalData []byte) (string, error) {
+	enc := key.Enc
+	if enc == "" {
+		enc = A256GCM
+	}
+
+	id, ok := encIDs[enc]
+	if !ok {
+		return "", fmt.Errorf("unknown enc: %+q", enc)
+	}
+
+	aead, err := newAEAD(enc, key.RawKey)
+	if err != nil {
+		return "", err
+	}
+
+	header := make([]byte, 2+keyRefSize+aead.NonceSize())
+	header[0] = compactVersion
+	header[1] = id
+	copy(header[2:], keyRef(key.KID))
+
+	nonce := header[2+keyRefSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "failed to get random nonce")
+	}
+
+	envelope := aead.Seal(header, nonce, plaintext, bindingData(enc, additionalData))
+	return compactPrefix + base64.RawURLEncoding.EncodeToString(envelope), nil
+}
+
+// openCompact decrypts a value in the compact envelope.
+func (key *EncryptionKey) openCompact(value string, additionalData []byte) ([]byte, error) {
+	envelope, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, compactPrefix))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid compact envelope")
+	}
+
+	if len(envelope) < 2+keyRefSize {
+		return nil, errors.New("compact envelope is too short")
+	}
+
+	if envelope[0] != compactVersion {
+		return nil, fmt.Errorf("attempt to decrypt compact envelope with unknown version %d", envelope[0])
+	}
+
+	enc := ""
+	for name, id := range encIDs {
+		if id == envelope[1] {
+			enc = name
+		}
+	}
+
+	if enc == "" {
+		return nil, fmt.Errorf("attempt to decrypt compact envelope with unknown enc %d", envelope[1])
+	}
+
+	if subtle.ConstantTimeCompare(envelope[2:2+keyRefSize], keyRef(key.KID)) != 1 {
+		return nil, fmt.Errorf("attempt to decrypt compact envelope using different KID %v", key.KID)
+	}
+
+	aead, err := newAEAD(enc, key.RawKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "attempt to decrypt compact envelope")
+	}
+
+	rest := envelope[2+keyRefSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("compact envelope is too short")
+	}
+
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, bindingData(enc, additionalData))
+	return plaintext, errors.Wrap(err, "failed to Open")
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestCompactEnvelopeRoundTrip(t *testing.T) {
+	key := &EncryptionKey{KID: "kid", RawKey: make([]byte, 32)}
+	source := "Hello, World!"
+
+	for _, enc := range []string{A256GCM, C20P, XC20P, A256SIV} {
+		key.Enc = enc
+		value, err := key.sealCompact([]byte(source), []byte("path"))
+		if err != nil {
+			t.Fatalf("failed to sealCompact with %s: %v", enc, err)
+		}
+
+		if !strings.HasPrefix(value, compactPrefix) {
+			t.Errorf("expected %q prefix, got %q", compactPrefix, value)
+		}
+
+		legacy, err := key.encrypt([]byte(source), []byte("path"))
+		if err != nil {
+			t.Fatalf("failed to encrypt with %s: %v", enc, err)
+		}
+
+		if encoded := base64.RawURLEncoding.EncodeToString(legacy); len(value)*2 > len(encoded) {
+			t.Errorf("expected compact envelope to be less than half the size of %q, got %q", encoded, value)
+		}
+
+		plaintext, err := key.openCompact(value, []byte("path"))
+		if err != nil {
+			t.Fatalf("failed to openCompact with %s: %v", enc, err)
+		}
+
+		if string(plaintext) != source {
+			t.Errorf("expected %q, got %q", source, plaintext)
+		}
+	}
+
+	other := &EncryptionKey{KID: "other", RawKey: make([]byte, 32)}
+	value, _ := other.sealCompact([]byte(source), nil)
+	if _, err := key.openCompact(value, nil); err == nil {
+		t.Error("expected openCompact to fail for a different KID")
+	}
+}
+
+func TestDecryptReadsCompactAndLegacyValues(t *testing.T) {
+	legacy, err := Encrypt([]byte(reencryptConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	decrypted, err := Decrypt(legacy)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	// switch to compact values with the same key, then put back the legacy ciphertext of the db password
+	compact := bytes.Replace(decrypted, []byte("encrypted = false"), []byte("encrypted = false\n\tcompact = true"), 1)
+	compact = bytes.Replace(compact, []byte("cache-secret"), []byte("new-cache-secret"), 1)
+	result, err := Reencrypt(compact, legacy)
+	if err != nil {
+		t.Fatal("failed to Reencrypt:", err)
+	}
+
+	legacyLines := bytes.Split(legacy, []byte("\n"))
+	resultLines := bytes.Split(result, []byte("\n"))
+	for i, line := range resultLines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("password")) {
+			for _, legacyLine := range legacyLines {
+				if bytes.HasPrefix(bytes.TrimSpace(legacyLine), []byte("password")) {
+					resultLines[i] = legacyLine
+					break
+				}
+			}
+			break
+		}
+	}
+	result = bytes.Join(resultLines, []byte("\n"))
+
+	if bytes.Count(result, []byte(compactPrefix)) != 1 {
+		t.Errorf("expected one compact value, got:\n%s", result)
+	}
+
+	plaintext, err := Decrypt(result)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if !bytes.Contains(plaintext, []byte(`"new-cache-secret"`)) || !bytes.Contains(plaintext, []byte(`"db-secret"`)) {
+		t.Errorf("unexpected decrypted contents:\n%s", plaintext)
+	}
+}
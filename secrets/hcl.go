@@ -0,0 +1,774 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/parser"
+	"github.com/hashicorp/hcl/hcl/printer"
+	"github.com/hashicorp/hcl/hcl/token"
+	"github.com/pkg/errors"
+)
+
+const (
+	formatHCL = "hcl"
+
+	// syntaxHCL2 in the header selects HCL2 for contents that are also valid HCL
+	syntaxHCL2 = "hcl2"
+)
+
+// Protected HCL blocks are encrypted as their text, including their comments.
+const valueTypeBlock = "hcl"
+
+var literalTypes = map[token.Type]string{
+	token.NUMBER: ValueNumber,
+	token.FLOAT:  ValueFloat,
+	token.BOOL:   ValueBool,
+}
+
+// hclFormat accepts all contents, and uses HCL2 when the header or the contents asks for it.
+type hclFormat struct{}
+
+func (hclFormat) Name() string {
+	return formatHCL
+}
+
+func (hclFormat) Extensions() []string {
+	return []string{".hcl", ".tf", ".tfvars"}
+}
+
+func (hclFormat) Detect(contents []byte) bool {
+	return true
+}
+
+func (hclFormat) Parse(contents []byte) (Document, error) {
+	tree, header, err := parseWithHeader(contents)
+	if err == nil && header.Syntax != syntaxHCL2 && !bytes.Contains(contents, []byte(`"`+typePrefix(valueTypeExpr))) {
+		return &hclDocument{file: tree, header: header}, nil
+	}
+
+	doc, hcl2Err := parseHCL2WithHeader(contents)
+	if hcl2Err != nil {
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse contents as HCL (%v) or HCL2 (%v)", err, hcl2Err)
+		}
+		return nil, hcl2Err
+	}
+
+	return doc, nil
+}
+
+// parseWithHeader parses the contents and decodes its 'eh' header.
+func parseWithHeader(contents []byte) (*ast.File, *Header, error) {
+	tree, err := hcl.ParseBytes(contents)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to ParseBytes")
+	}
+
+	header, err := decodeHeader(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tree, header, nil
+}
+
+// decodeHeader decodes the 'eh' element of the file.
+func decodeHeader(file *ast.File) (*Header, error) {
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, errors.New("failed, unexpected .hcl format")
+	}
+
+	var eh, includes []*ast.ObjectItem
+	for _, item := range list.Filter("eh").Items {
+		obj, ok := item.Val.(*ast.ObjectType)
+		if !ok {
+			eh = append(eh, item)
+			continue
+		}
+
+		var rest []*ast.ObjectItem
+		for _, i := range obj.List.Items {
+			if len(i.Keys) > 0 && keyName(i.Keys[0]) == "include" {
+				includes = append(includes, i)
+			} else {
+				rest = append(rest, i)
+			}
+		}
+
+		eh = append(eh, &ast.ObjectItem{Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "eh"}}}, Val: &ast.ObjectType{List: &ast.ObjectList{Items: rest}}})
+	}
+
+	var wrapper Wrapper
+	if err := hcl.DecodeObject(&wrapper, &ast.File{Node: &ast.ObjectList{Items: eh}}); err != nil {
+		return nil, errors.Wrap(err, "failed to DecodeObject")
+	}
+
+	for _, item := range includes {
+		if len(item.Keys) > 1 {
+			return nil, fmt.Errorf("failed, include blocks cannot have labels at %s", item.Pos())
+		}
+
+		if err := decodeIncludes(&wrapper.Header, item.Val); err != nil {
+			return nil, err
+		}
+	}
+
+	return &wrapper.Header, nil
+}
+
+// decodeIncludes adds the includes of the node, a path, a block or a list of them, to Include and IncludeBlocks of the header.
+func decodeIncludes(header *Header, node ast.Node) error {
+	switch n := node.(type) {
+	case *ast.ListType:
+		for _, item := range n.List {
+			if err := decodeIncludes(header, item); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LiteralType:
+		var path string
+		if err := hcl.DecodeObject(&path, n); err != nil {
+			return errors.Wrap(err, "failed to decode include")
+		}
+		header.Include = append(header.Include, path)
+
+	case *ast.ObjectType:
+		var include Include
+		if err := hcl.DecodeObject(&include, n); err != nil {
+			return errors.Wrap(err, "failed to decode include")
+		}
+		header.IncludeBlocks = append(header.IncludeBlocks, include)
+
+	default:
+		return fmt.Errorf("failed, invalid include at %s", node.Pos())
+	}
+
+	return nil
+}
+
+// hclDocument is a document in HCL syntax.
+type hclDocument struct {
+	file   *ast.File
+	header *Header
+}
+
+func (d *hclDocument) Header() (*Header, error) {
+	return d.header, nil
+}
+
+func (d *hclDocument) SetHeaderValue(name string, value interface{}) error {
+	entry, err := getHeaderValue(d.file, name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to getHeaderValue for %q", name)
+	}
+
+	switch v := value.(type) {
+	case string:
+		entry.Token.Text = strconv.Quote(v)
+	case bool:
+		entry.Token.Text = strconv.FormatBool(v)
+	default:
+		return fmt.Errorf("failed, unsupported value of %q: %v", name, value)
+	}
+
+	return nil
+}
+
+func (d *hclDocument) RemoveHeader() error {
+	list, ok := d.file.Node.(*ast.ObjectList)
+	if !ok {
+		return errors.New("failed, unexpected .hcl format")
+	}
+
+	for i, item := range list.Items {
+		if item.Keys[0].Token.Text == "eh" {
+			list.Items = append(list.Items[:i], list.Items[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (d *hclDocument) Bytes() ([]byte, error) {
+	return FormatASTFile(d.file)
+}
+
+// Decode decodes the syntax tree with github.com/hashicorp/hcl.
+func (d *hclDocument) Decode(out interface{}) error {
+	err := decodeSecrets(out, func(target interface{}) error {
+		return hcl.DecodeObject(target, d.file)
+	})
+	if posErr, ok := err.(*parser.PosError); ok {
+		if path := d.pathAt(posErr.Pos); path != "" {
+			return &DecodeError{Path: path, Err: err}
+		}
+	}
+
+	return err
+}
+
+// pathAt returns the path of the innermost value at the line of the position, or "".
+func (d *hclDocument) pathAt(pos token.Pos) string {
+	var path string
+	d.Walk(func(v Value) error {
+		if v.(*hclValue).node.Pos().Line == pos.Line {
+			path = v.Path()
+		}
+		return nil
+	})
+
+	return path
+}
+
+// Merge merges the items of the fragment, matched by all of their keys, into the document.
+func (d *hclDocument) Merge(fragment Document, resolve func(path string) bool) error {
+	f, ok := fragment.(*hclDocument)
+	if !ok {
+		return errNotMergeable
+	}
+
+	list, ok := d.file.Node.(*ast.ObjectList)
+	if !ok {
+		return errors.New("failed, unexpected .hcl format")
+	}
+
+	fragmentList, ok := f.file.Node.(*ast.ObjectList)
+	if !ok {
+		return errors.New("failed, unexpected .hcl format")
+	}
+
+	// the printer relies on positions, so the fragment is moved after the end of the document. Its lead and line comments are
+	// printed with its items, other comments are dropped.
+	end := endPos(d.file)
+	shiftPositions(f.file, f.file.Comments, func(pos token.Pos) token.Pos {
+		pos.Line += end.Line + 1
+		pos.Offset += end.Offset + 1
+		return pos
+	})
+
+	mergeObjectList(list, fragmentList, "", resolve)
+	return nil
+}
+
+// mergeObjectList merges the items of the fragment into the list.
+func mergeObjectList(list *ast.ObjectList, fragment *ast.ObjectList, path string, resolve func(path string) bool) {
+	for _, item := range fragment.Items {
+		itemPath := path
+		for _, k := range item.Keys {
+			itemPath = joinPath(itemPath, keyName(k))
+		}
+
+		existing := findItem(list, item.Keys)
+		if existing == nil {
+			list.Add(item)
+			continue
+		}
+
+		obj, isObject := existing.Val.(*ast.ObjectType)
+		fragmentObj, isFragmentObject := item.Val.(*ast.ObjectType)
+		if isObject && isFragmentObject {
+			mergeObjectList(obj.List, fragmentObj.List, itemPath, resolve)
+			continue
+		}
+
+		if hclText(existing.Val) != hclText(item.Val) && resolve(itemPath) {
+			existing.Val = item.Val
+		}
+	}
+}
+
+// findItem returns the first item of the list with the same keys.
+func findItem(list *ast.ObjectList, keys []*ast.ObjectKey) *ast.ObjectItem {
+	for _, item := range list.Items {
+		if len(item.Keys) != len(keys) {
+			continue
+		}
+
+		same := true
+		for i, k := range item.Keys {
+			if keyName(k) != keyName(keys[i]) {
+				same = false
+			}
+		}
+
+		if same {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// hclText returns the printed node, it is used to compare values.
+func hclText(node ast.Node) string {
+	var c printer.Config
+	var text bytes.Buffer
+	if err := c.Fprint(&text, node); err != nil {
+		return ""
+	}
+	defer wipe(text.Bytes())
+
+	return text.String()
+}
+
+// endPos returns the last position in the file.
+func endPos(file *ast.File) token.Pos {
+	var end token.Pos
+	shiftPositions(file, file.Comments, func(pos token.Pos) token.Pos {
+		if pos.Offset > end.Offset {
+			end = pos
+		}
+		return pos
+	})
+
+	return end
+}
+
+func (d *hclDocument) Walk(fn func(Value) error) error {
+	list, ok := d.file.Node.(*ast.ObjectList)
+	if !ok {
+		return errors.New("failed, unexpected .hcl format")
+	}
+
+	for _, item := range list.Items {
+		if len(item.Keys) == 1 && item.Keys[0].Token.Text == "eh" {
+			// do not process eh element
+			continue
+		}
+
+		if err := d.walkItem(fn, nil, nil, "", item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkItem walks the value of the item. Its keys and path include all keys, its names only the first.
+func (d *hclDocument) walkItem(fn func(Value) error, names []string, keys []string, path string, item *ast.ObjectItem) error {
+	names = append(names[:len(names):len(names)], item.Keys[0].Token.Text)
+	keys = keys[:len(keys):len(keys)]
+	for _, k := range item.Keys {
+		keys = append(keys, keyName(k))
+		path = joinPath(path, keyName(k))
+	}
+
+	return d.walkNode(fn, &hclValue{doc: d, names: names, keys: keys, path: path, item: item, node: item.Val})
+}
+
+// walkNode walks the value. The items of lists share the names and path of the list.
+func (d *hclDocument) walkNode(fn func(Value) error, v *hclValue) error {
+	switch t := v.node.(type) {
+	case *ast.ListType:
+		for _, node := range t.List {
+			if err := d.walkNode(fn, &hclValue{doc: d, names: v.names, keys: v.keys, path: v.path, node: node}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *ast.ObjectType, *ast.LiteralType:
+	default:
+		return fmt.Errorf("failed because of unknown node type %v", reflect.TypeOf(t))
+	}
+
+	if err := fn(v); err != nil {
+		if err == SkipValue {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to process %q", v.path)
+	}
+
+	if obj, ok := v.node.(*ast.ObjectType); ok {
+		for _, item := range obj.List.Items {
+			if err := d.walkItem(fn, v.names, v.keys, v.path, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hclValue is a block or a literal in an HCL document.
+type hclValue struct {
+	doc   *hclDocument
+	names []string
+	keys  []string
+	path  string
+
+	// item is the item that has the value, it is nil for values in lists
+	item *ast.ObjectItem
+	node ast.Node
+}
+
+func (v *hclValue) Names() []string {
+	return v.names
+}
+
+func (v *hclValue) Keys() []string {
+	return v.keys
+}
+
+func (v *hclValue) Path() string {
+	return v.path
+}
+
+// Annotated tells if the item or the literal has an eh:protect lead or line comment.
+func (v *hclValue) Annotated() bool {
+	var groups []*ast.CommentGroup
+	if v.item != nil {
+		groups = append(groups, v.item.LeadComment, v.item.LineComment)
+	}
+
+	if lit, ok := v.node.(*ast.LiteralType); ok {
+		groups = append(groups, lit.LeadComment, lit.LineComment)
+	}
+
+	for _, group := range groups {
+		if group != nil && isProtectComment(group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isProtectComment tells if one of the comments is an eh:protect annotation.
+func isProtectComment(group *ast.CommentGroup) bool {
+	for _, c := range group.List {
+		text := strings.TrimSpace(c.Text)
+		switch {
+		case strings.HasPrefix(text, "//"):
+			text = text[2:]
+		case strings.HasPrefix(text, "#"):
+			text = text[1:]
+		case strings.HasPrefix(text, "/*"):
+			text = strings.TrimSuffix(text[2:], "*/")
+		}
+
+		if strings.TrimSpace(text) == protectAnnotation {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *hclValue) Get() (string, string, error) {
+	switch t := v.node.(type) {
+	case *ast.ObjectType:
+		if v.item == nil {
+			return "", valueTypeBlock, &UnsupportedError{Reason: "object in a list"}
+		}
+
+		if len(v.item.Keys) > 1 {
+			return "", valueTypeBlock, &UnsupportedError{Reason: "block with labels"}
+		}
+
+		text, err := v.printBlock(t)
+		if err != nil {
+			return "", "", err
+		}
+
+		return text, valueTypeBlock, nil
+	case *ast.LiteralType:
+		switch t.Token.Type {
+		case token.STRING:
+			value, err := strconv.Unquote(t.Token.Text)
+			if err != nil {
+				return "", "", errors.Wrapf(err, "failed to Unquote %q", v.path)
+			}
+			return value, ValueString, nil
+		case token.HEREDOC:
+			// only the body is protected, the marker lines are kept so that they can be restored exactly
+			return splitHeredoc(t.Token.Text).body, ValueString, nil
+		}
+
+		if valueType, ok := literalTypes[t.Token.Type]; ok {
+			return t.Token.Text, valueType, nil
+		}
+
+		return "", "", &UnsupportedError{Reason: fmt.Sprintf("%s value", t.Token.Type)}
+	default:
+		return "", "", fmt.Errorf("failed because of unknown node type %v", reflect.TypeOf(t))
+	}
+}
+
+func (v *hclValue) Set(text string, valueType string) error {
+	switch t := v.node.(type) {
+	case *ast.ObjectType:
+		if valueType != ValueString {
+			return fmt.Errorf("failed, cannot replace block %q with %s value", v.path, valueType)
+		}
+
+		v.replaceBlock(t, text)
+		return nil
+	case *ast.LiteralType:
+		if t.Token.Type == token.HEREDOC && valueType == ValueString {
+			h := splitHeredoc(t.Token.Text)
+			if isLegacyHeredoc(h, text) {
+				// older versions encrypted the whole heredoc, including the marker lines
+				t.Token.Text = text
+				return nil
+			}
+
+			h.body = text
+			if text != "" && !strings.HasSuffix(text, "\n") {
+				h.body += "\n"
+			}
+			t.Token.Text = h.String()
+			return nil
+		}
+
+		switch valueType {
+		case ValueString:
+			t.Token.Type = token.STRING
+			t.Token.Text = strconv.Quote(text)
+			return nil
+		case valueTypeBlock:
+			return v.restoreBlock(t, text)
+		}
+
+		for tokenType, literalType := range literalTypes {
+			if literalType == valueType {
+				t.Token.Type = tokenType
+				t.Token.Text = text
+				return nil
+			}
+		}
+
+		return fmt.Errorf("failed, unknown type %q of %q", valueType, v.path)
+	default:
+		return fmt.Errorf("failed because of unknown node type %v", reflect.TypeOf(t))
+	}
+}
+
+// blockComments returns the comments of the file that are inside the block and the ones outside of it.
+func (v *hclValue) blockComments(obj *ast.ObjectType) (inside []*ast.CommentGroup, outside []*ast.CommentGroup) {
+	for _, c := range v.doc.file.Comments {
+		if c.Pos().After(obj.Lbrace) && c.Pos().Before(obj.Rbrace) {
+			inside = append(inside, c)
+		} else {
+			outside = append(outside, c)
+		}
+	}
+
+	return inside, outside
+}
+
+// printBlock returns the text of the block and its comments.
+func (v *hclValue) printBlock(obj *ast.ObjectType) (string, error) {
+	// the item is printed as a whole so that the block is formatted as it would be in the file
+	block := &ast.File{Node: &ast.ObjectList{Items: []*ast.ObjectItem{{Keys: v.item.Keys, Val: obj}}}}
+	block.Comments, _ = v.blockComments(obj)
+
+	var c printer.Config
+	var text bytes.Buffer
+	if err := c.Fprint(&text, block); err != nil {
+		return "", errors.Wrapf(err, "failed to print block %q", v.path)
+	}
+	defer wipe(text.Bytes())
+
+	return text.String(), nil
+}
+
+// replaceBlock replaces the block and the comments inside it with the encrypted string.
+func (v *hclValue) replaceBlock(obj *ast.ObjectType, encoded string) {
+	_, v.doc.file.Comments = v.blockComments(obj)
+
+	v.item.Assign = v.item.Keys[0].Pos()
+	v.item.Val = &ast.LiteralType{
+		Token: token.Token{
+			Type: token.STRING,
+			Pos:  obj.Lbrace,
+			Text: strconv.Quote(encoded),
+		},
+	}
+	v.node = v.item.Val
+}
+
+// restoreBlock restores the block that was replaced by replaceBlock.
+func (v *hclValue) restoreBlock(lit *ast.LiteralType, plaintext string) error {
+	if v.item == nil {
+		return fmt.Errorf("failed, encrypted block %q is not the value of an item", v.path)
+	}
+
+	block, err := hcl.ParseString(plaintext)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse decrypted block %q", v.path)
+	}
+
+	list, ok := block.Node.(*ast.ObjectList)
+	if !ok || len(list.Items) != 1 {
+		return fmt.Errorf("failed, invalid decrypted block %q", v.path)
+	}
+
+	obj, ok := list.Items[0].Val.(*ast.ObjectType)
+	if !ok {
+		return fmt.Errorf("failed, invalid decrypted block %q", v.path)
+	}
+
+	// The printer relies on positions to place comments and blank lines. Make room for the block
+	// after the item, as if its text was pasted into the file, and move the block there.
+	file := v.doc.file
+	start := v.item.Keys[0].Pos()
+	lines := strings.Count(strings.TrimRight(plaintext, "\n"), "\n")
+
+	shiftPositions(file, file.Comments, func(pos token.Pos) token.Pos {
+		if pos.Line > start.Line {
+			pos.Line += lines
+			pos.Offset += len(plaintext)
+		}
+		return pos
+	})
+
+	shiftPositions(obj, block.Comments, func(pos token.Pos) token.Pos {
+		pos.Line += start.Line - 1
+		pos.Offset += start.Offset
+		return pos
+	})
+
+	v.item.Assign = token.Pos{}
+	if list.Items[0].Assign.IsValid() {
+		v.item.Assign = start
+	}
+	v.item.Val = obj
+	v.node = obj
+
+	file.Comments = append(file.Comments, block.Comments...)
+	sort.SliceStable(file.Comments, func(i, j int) bool {
+		return file.Comments[i].Pos().Offset < file.Comments[j].Pos().Offset
+	})
+
+	return nil
+}
+
+// shiftPositions moves every position in the node and the comments, each comment once.
+func shiftPositions(node ast.Node, comments []*ast.CommentGroup, shift func(token.Pos) token.Pos) {
+	ast.Walk(node, func(n ast.Node) (ast.Node, bool) {
+		switch t := n.(type) {
+		case *ast.ObjectItem:
+			if t.Assign.IsValid() {
+				t.Assign = shift(t.Assign)
+			}
+		case *ast.ObjectKey:
+			t.Token.Pos = shift(t.Token.Pos)
+		case *ast.LiteralType:
+			t.Token.Pos = shift(t.Token.Pos)
+		case *ast.ListType:
+			t.Lbrack = shift(t.Lbrack)
+			t.Rbrack = shift(t.Rbrack)
+		case *ast.ObjectType:
+			t.Lbrace = shift(t.Lbrace)
+			t.Rbrace = shift(t.Rbrace)
+		}
+		return n, true
+	})
+
+	for _, group := range comments {
+		for _, c := range group.List {
+			c.Start = shift(c.Start)
+		}
+	}
+}
+
+// keyName returns the unquoted name of the object key.
+func keyName(k *ast.ObjectKey) string {
+	if k.Token.Type == token.STRING {
+		if name, err := strconv.Unquote(k.Token.Text); err == nil {
+			return name
+		}
+	}
+
+	return k.Token.Text
+}
+
+// heredoc is the text of a heredoc token split into the marker line, the body and the terminator.
+type heredoc struct {
+	marker     string
+	body       string
+	terminator string
+}
+
+func splitHeredoc(text string) heredoc {
+	text = strings.TrimSuffix(text, "\n")
+
+	var h heredoc
+	i := strings.IndexByte(text, '\n')
+	if i < 0 {
+		h.marker = text
+		return h
+	}
+
+	h.marker, text = text[:i], text[i+1:]
+
+	j := strings.LastIndexByte(text, '\n')
+	h.body, h.terminator = text[:j+1], text[j+1:]
+	return h
+}
+
+// String returns the heredoc token text. It ends with a newline like the text of a scanned token.
+func (h heredoc) String() string {
+	return h.marker + "\n" + h.body + h.terminator + "\n"
+}
+
+// isLegacyHeredoc tells if the body is a whole heredoc, as encrypted by older versions.
+func isLegacyHeredoc(h heredoc, plaintext string) bool {
+	if h.marker != "<<HEREDOC" || !strings.HasPrefix(plaintext, "<<") {
+		return false
+	}
+
+	legacy := splitHeredoc(plaintext)
+	return strings.TrimSpace(legacy.terminator) == strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(legacy.marker), "<<"), "-")
+}
+
+func getHeaderValue(node ast.Node, name string) (*ast.LiteralType, error) {
+	var list *ast.ObjectList
+
+	file, ok := node.(*ast.File)
+	if ok {
+		list, ok = file.Node.(*ast.ObjectList)
+	} else {
+		list, ok = node.(*ast.ObjectList)
+	}
+
+	if !ok {
+		return nil, errors.New("failed, unexpected .hcl format")
+	}
+
+	eh := list.Filter("eh")
+	if len(eh.Items) != 1 {
+		return nil, errors.New("failed, must have 'eh' element")
+	}
+
+	obj, ok := eh.Items[0].Val.(*ast.ObjectType)
+	if !ok {
+		return nil, errors.New("failed, invalid 'eh' element")
+	}
+
+	keyEntry := obj.List.Filter(name)
+	if len(keyEntry.Items) == 0 {
+		return nil, fmt.Errorf("failed, no %q element found in 'eh'", name)
+	}
+
+	if len(keyEntry.Items) > 1 {
+		return nil, fmt.Errorf("failed, multiple %q elements found in 'eh'", name)
+	}
+
+	val, ok := keyEntry.Items[0].Val.(*ast.LiteralType)
+	if !ok {
+		return nil, fmt.Errorf("failed, invalid %q element in 'eh'", name)
+	}
+
+	return val, nil
+}
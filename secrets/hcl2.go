@@ -0,0 +1,500 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Templates, function calls and other HCL2 expressions are encrypted as their text.
+const valueTypeExpr = "expr"
+
+// hcl2Document is edited with hclwrite, which keeps comments and formatting.
+type hcl2Document struct {
+	file   *hclwrite.File
+	header *Header
+}
+
+// parseHCL2WithHeader parses the contents as HCL2 and decodes its 'eh' block.
+func parseHCL2WithHeader(contents []byte) (*hcl2Document, error) {
+	file, diags := hclwrite.ParseConfig(contents, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, errors.Wrap(diags, "failed to parse HCL2")
+	}
+
+	native, diags := hclsyntax.ParseConfig(contents, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, errors.Wrap(diags, "failed to parse HCL2")
+	}
+
+	var eh *hclsyntax.Block
+	for _, block := range native.Body.(*hclsyntax.Body).Blocks {
+		if block.Type == "eh" && len(block.Labels) == 0 {
+			if eh != nil {
+				return nil, errors.New("failed, multiple 'eh' blocks")
+			}
+			eh = block
+		}
+	}
+
+	if eh == nil {
+		return nil, errors.New("failed, must have 'eh' block")
+	}
+
+	values, err := hcl2BodyValues(eh.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' block")
+	}
+
+	// cty values have no decoder for Header, their JSON does
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' block")
+	}
+
+	var header Header
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' block")
+	}
+
+	return &hcl2Document{file: file, header: &header}, nil
+}
+
+// hcl2BodyValues returns the values of the attributes and nested blocks, which must be constant.
+func hcl2BodyValues(body *hclsyntax.Body) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for name, attr := range body.Attributes {
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "failed to evaluate %q", name)
+		}
+
+		encoded, err := ctyjson.SimpleJSONValue{Value: value}.MarshalJSON()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to convert %q", name)
+		}
+
+		values[name] = json.RawMessage(encoded)
+	}
+
+	for _, block := range body.Blocks {
+		blockValues, err := hcl2BodyValues(block.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		// there can be more than one include block, they are decoded into IncludeBlocks
+		if block.Type == "include" {
+			list, _ := values["includeBlocks"].([]interface{})
+			values["includeBlocks"] = append(list, blockValues)
+			continue
+		}
+
+		values[block.Type] = blockValues
+	}
+
+	return values, nil
+}
+
+func (d *hcl2Document) Header() (*Header, error) {
+	return d.header, nil
+}
+
+func (d *hcl2Document) Walk(fn func(Value) error) error {
+	return d.walkBody(fn, nil, nil, "", d.file.Body())
+}
+
+// walkBody walks the attributes of the body and of its nested blocks. Block types are names of the attributes inside, and labels are only keys.
+func (d *hcl2Document) walkBody(fn func(Value) error, names []string, keys []string, path string, body *hclwrite.Body) error {
+	attributes := body.Attributes()
+	attrNames := make([]string, 0, len(attributes))
+	for name := range attributes {
+		attrNames = append(attrNames, name)
+	}
+	sort.Strings(attrNames)
+
+	for _, name := range attrNames {
+		attr := &hcl2Attribute{
+			names: append(names[:len(names):len(names)], name),
+			keys:  append(keys[:len(keys):len(keys)], name),
+			path:  joinPath(path, name),
+			body:  body,
+			name:  name,
+		}
+
+		if err := d.walkAttribute(fn, attr); err != nil {
+			return errors.Wrapf(err, "failed to process %q", attr.path)
+		}
+	}
+
+	for _, block := range body.Blocks() {
+		if path == "" && block.Type() == "eh" && len(block.Labels()) == 0 {
+			// do not process eh block
+			continue
+		}
+
+		blockKeys := append(keys[:len(keys):len(keys)], block.Type())
+		blockPath := joinPath(path, block.Type())
+		for _, label := range block.Labels() {
+			blockKeys = append(blockKeys, label)
+			blockPath = joinPath(blockPath, label)
+		}
+
+		if err := d.walkBody(fn, append(names[:len(names):len(names)], block.Type()), blockKeys, blockPath, block.Body()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkAttribute walks the value of the attribute, or every element if it is a list.
+func (d *hcl2Document) walkAttribute(fn func(Value) error, attr *hcl2Attribute) error {
+	tuple, _, err := attr.tuple()
+	if err != nil {
+		return err
+	}
+
+	if tuple == nil {
+		if err := fn(attr); err != nil && err != SkipValue {
+			return err
+		}
+		return nil
+	}
+
+	for i := range tuple.Exprs {
+		if err := fn(&hcl2Element{attr: attr, index: i}); err != nil && err != SkipValue {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hcl2Attribute is an attribute in an HCL2 document.
+type hcl2Attribute struct {
+	names []string
+	keys  []string
+	path  string
+	body  *hclwrite.Body
+	name  string
+}
+
+func (a *hcl2Attribute) Names() []string {
+	return a.names
+}
+
+func (a *hcl2Attribute) Keys() []string {
+	return a.keys
+}
+
+func (a *hcl2Attribute) Path() string {
+	return a.path
+}
+
+func (a *hcl2Attribute) Get() (string, string, error) {
+	expr, text, err := a.expr()
+	if err != nil {
+		return "", "", err
+	}
+
+	value, valueType := hcl2Value(expr, text)
+	return value, valueType, nil
+}
+
+// expr parses the expression of the attribute and returns it with its text.
+func (a *hcl2Attribute) expr() (hclsyntax.Expression, string, error) {
+	text := strings.TrimSpace(string(a.body.GetAttribute(a.name).Expr().BuildTokens(nil).Bytes()))
+
+	expr, diags := hclsyntax.ParseExpression([]byte(text), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, "", errors.Wrapf(diags, "failed to parse value of %q", a.path)
+	}
+
+	return expr, text, nil
+}
+
+// tuple returns the expression of the attribute and its text if it is a list, or nil.
+func (a *hcl2Attribute) tuple() (*hclsyntax.TupleConsExpr, string, error) {
+	expr, text, err := a.expr()
+	if err != nil {
+		return nil, "", err
+	}
+
+	tuple, _ := expr.(*hclsyntax.TupleConsExpr)
+	return tuple, text, nil
+}
+
+func (a *hcl2Attribute) Set(text string, valueType string) error {
+	if valueType == ValueString {
+		a.body.SetAttributeValue(a.name, cty.StringVal(text))
+		return nil
+	}
+
+	tokens, err := hcl2Tokens(text)
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore value of %q", a.path)
+	}
+
+	a.body.SetAttributeRaw(a.name, tokens)
+	return nil
+}
+
+// hcl2Element is an element of a list. The list is parsed again on every access, since Set moves later elements.
+type hcl2Element struct {
+	attr  *hcl2Attribute
+	index int
+}
+
+func (e *hcl2Element) Names() []string {
+	return e.attr.names
+}
+
+func (e *hcl2Element) Keys() []string {
+	return e.attr.keys
+}
+
+func (e *hcl2Element) Path() string {
+	return e.attr.path
+}
+
+func (e *hcl2Element) Get() (string, string, error) {
+	tuple, text, err := e.attr.tuple()
+	if err != nil {
+		return "", "", err
+	}
+
+	expr := tuple.Exprs[e.index]
+	rng := expr.Range()
+	value, valueType := hcl2Value(expr, text[rng.Start.Byte:rng.End.Byte])
+	return value, valueType, nil
+}
+
+func (e *hcl2Element) Set(text string, valueType string) error {
+	tuple, list, err := e.attr.tuple()
+	if err != nil {
+		return err
+	}
+
+	if valueType == ValueString {
+		text = string(hclwrite.TokensForValue(cty.StringVal(text)).Bytes())
+	}
+
+	rng := tuple.Exprs[e.index].Range()
+	tokens, err := hcl2Tokens(list[:rng.Start.Byte] + text + list[rng.End.Byte:])
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore value of %q", e.attr.path)
+	}
+
+	e.attr.body.SetAttributeRaw(e.attr.name, tokens)
+	return nil
+}
+
+// hcl2Value returns the value to encrypt and its type.
+func hcl2Value(expr hclsyntax.Expression, text string) (string, string) {
+	switch t := expr.(type) {
+	case *hclsyntax.TemplateExpr:
+		if t.IsStringLiteral() && strings.HasPrefix(text, `"`) {
+			if value, diags := t.Value(nil); !diags.HasErrors() {
+				return value.AsString(), ValueString
+			}
+		}
+	case *hclsyntax.LiteralValueExpr:
+		switch t.Val.Type() {
+		case cty.Number:
+			return text, ValueNumber
+		case cty.Bool:
+			return text, ValueBool
+		}
+	}
+
+	return text, valueTypeExpr
+}
+
+// hcl2Tokens lexes the text of an expression into tokens that can be written with hclwrite.
+func hcl2Tokens(text string) (hclwrite.Tokens, error) {
+	lexed, diags := hclsyntax.LexExpression([]byte(text), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var tokens hclwrite.Tokens
+	end := 0
+	for _, t := range lexed {
+		if t.Type == hclsyntax.TokenEOF {
+			break
+		}
+
+		tokens = append(tokens, &hclwrite.Token{
+			Type:         t.Type,
+			Bytes:        t.Bytes,
+			SpacesBefore: t.Range.Start.Byte - end,
+		})
+		end = t.Range.End.Byte
+	}
+
+	return tokens, nil
+}
+
+func (d *hcl2Document) headerBody() (*hclwrite.Body, error) {
+	block := d.file.Body().FirstMatchingBlock("eh", nil)
+	if block == nil {
+		return nil, errors.New("failed, must have 'eh' block")
+	}
+
+	return block.Body(), nil
+}
+
+func (d *hcl2Document) SetHeaderValue(name string, value interface{}) error {
+	header, err := d.headerBody()
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case string:
+		header.SetAttributeValue(name, cty.StringVal(v))
+	case bool:
+		header.SetAttributeValue(name, cty.BoolVal(v))
+	default:
+		return fmt.Errorf("failed, unsupported value of %q: %v", name, value)
+	}
+
+	return nil
+}
+
+func (d *hcl2Document) RemoveHeader() error {
+	block := d.file.Body().FirstMatchingBlock("eh", nil)
+	if block != nil {
+		d.file.Body().RemoveBlock(block)
+	}
+
+	return nil
+}
+
+func (d *hcl2Document) Bytes() ([]byte, error) {
+	return d.file.Bytes(), nil
+}
+
+// Decode parses the text again and decodes it with gohcl.
+func (d *hcl2Document) Decode(out interface{}) error {
+	text := d.file.Bytes()
+	defer wipe(text)
+
+	file, diags := hclsyntax.ParseConfig(text, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return errors.Wrap(diags, "failed to parse HCL2")
+	}
+
+	return decodeSecrets(out, func(target interface{}) error {
+		diags := gohcl.DecodeBody(file.Body, nil, target)
+		if !diags.HasErrors() {
+			return nil
+		}
+
+		for _, diag := range diags {
+			if diag.Subject == nil {
+				continue
+			}
+
+			if path := hcl2PathAt(file.Body.(*hclsyntax.Body), "", diag.Subject.Start); path != "" {
+				return &DecodeError{Path: path, Err: diags}
+			}
+		}
+
+		return diags
+	})
+}
+
+// hcl2PathAt returns the path of the attribute of the body or its nested blocks at the position, or "".
+func hcl2PathAt(body *hclsyntax.Body, path string, pos hcl.Pos) string {
+	for name, attr := range body.Attributes {
+		if attr.SrcRange.ContainsPos(pos) {
+			return joinPath(path, name)
+		}
+	}
+
+	for _, block := range body.Blocks {
+		if !block.Body.SrcRange.ContainsPos(pos) {
+			continue
+		}
+
+		blockPath := joinPath(path, block.Type)
+		for _, label := range block.Labels {
+			blockPath = joinPath(blockPath, label)
+		}
+
+		return hcl2PathAt(block.Body, blockPath, pos)
+	}
+
+	return ""
+}
+
+// Merge merges the attributes and blocks, matched by type and labels, of the fragment into the document.
+func (d *hcl2Document) Merge(fragment Document, resolve func(path string) bool) error {
+	f, ok := fragment.(*hcl2Document)
+	if !ok {
+		return errNotMergeable
+	}
+
+	mergeHCL2Body(d.file.Body(), f.file.Body(), "", resolve)
+	return nil
+}
+
+func mergeHCL2Body(body *hclwrite.Body, fragment *hclwrite.Body, path string, resolve func(path string) bool) {
+	attributes := fragment.Attributes()
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tokens := attributes[name].Expr().BuildTokens(nil)
+
+		existing := body.GetAttribute(name)
+		if existing == nil {
+			body.SetAttributeRaw(name, tokens)
+			continue
+		}
+
+		same := bytes.Equal(bytes.TrimSpace(existing.Expr().BuildTokens(nil).Bytes()), bytes.TrimSpace(tokens.Bytes()))
+		if !same && resolve(joinPath(path, name)) {
+			body.SetAttributeRaw(name, tokens)
+		}
+	}
+
+	for _, block := range fragment.Blocks() {
+		existing := body.FirstMatchingBlock(block.Type(), block.Labels())
+		if existing == nil {
+			fragment.RemoveBlock(block)
+			body.AppendBlock(block)
+			continue
+		}
+
+		blockPath := joinPath(path, block.Type())
+		for _, label := range block.Labels() {
+			blockPath = joinPath(blockPath, label)
+		}
+
+		mergeHCL2Body(existing.Body(), block.Body(), blockPath, resolve)
+	}
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
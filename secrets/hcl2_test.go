@@ -0,0 +1,157 @@
+package secrets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const hcl2Config = `
+eh {
+  encrypted = false
+  key       = ""
+  service {
+    type = "local"
+  }
+  protect = ["password", "token", "port", "enabled", "hosts", "credentials"]
+}
+
+locals {
+  # names of the replicas
+  replicas = [for i in range(3) : "db-${i}"]
+}
+
+resource "aws_db_instance" "main" {
+  name     = "main"
+  password = "correct horse" // rotated monthly
+  port     = 5432
+  enabled  = true
+  hosts    = ["a.example.com", "b.example.com"]
+  token    = "${local.replicas[0]}-token"
+}
+
+credentials {
+  user   = "admin"
+  secret = "battery staple"
+}
+`
+
+func TestHCL2RoundTrip(t *testing.T) {
+	encrypted, err := Encrypt([]byte(hcl2Config))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{"correct horse", "5432", "a.example.com", "-token", `"admin"`, "battery staple"} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	for _, kept := range []string{"# names of the replicas", "// rotated monthly", `[for i in range(3) : "db-${i}"]`, `resource "aws_db_instance" "main" {`, "encrypted = true"} {
+		if !bytes.Contains(encrypted, []byte(kept)) {
+			t.Errorf("expected %q to be kept, got:\n%s", kept, encrypted)
+		}
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if strings.TrimSpace(string(decrypted)) != strings.TrimSpace(hcl2Config) {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
+
+func TestHCL2SelectedByHeader(t *testing.T) {
+	contents := strings.Replace(sivConfig, `cipher = "A256SIV"`, `syntax = "hcl2"`, 1)
+
+	_, doc, header, err := parseDocument("", []byte(contents))
+	if err != nil {
+		t.Fatal("failed to parseDocument:", err)
+	}
+
+	if _, ok := doc.(*hcl2Document); !ok || header.Syntax != syntaxHCL2 {
+		t.Errorf("expected HCL2 document with syntax %q, got %T with %q", syntaxHCL2, doc, header.Syntax)
+	}
+
+	encrypted, err := Encrypt([]byte(contents))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if bytes.Count(decrypted, []byte(`"same-secret"`)) != 2 {
+		t.Errorf("expected decrypted contents to include the passwords, got:\n%s", decrypted)
+	}
+}
+
+func TestHCL2EncryptsListElements(t *testing.T) {
+	contents := `eh {
+  encrypted = false
+  key       = ""
+  service {
+    type = "local"
+  }
+  protect = ["hosts"]
+}
+
+hosts = [
+  "a.example.com", # primary
+  5432,
+  true,
+  local.fallback,
+]
+`
+
+	encrypted, err := Encrypt([]byte(contents))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	hosts := encrypted[bytes.Index(encrypted, []byte("hosts = [")):]
+	for _, plaintext := range []string{"a.example.com", "5432", "true", "local.fallback"} {
+		if bytes.Contains(hosts, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	for _, prefix := range []string{typePrefix(ValueNumber), typePrefix(ValueBool), typePrefix(valueTypeExpr)} {
+		if bytes.Count(encrypted, []byte(`"`+prefix)) != 1 || !bytes.Contains(encrypted, []byte("# primary")) {
+			t.Errorf("expected every element to be encrypted on its own, got:\n%s", encrypted)
+		}
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if string(decrypted) != contents {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+
+	// rotating one element keeps the ciphertexts of the others
+	result, err := Reencrypt(bytes.Replace(decrypted, []byte("5432"), []byte("5433"), 1), encrypted)
+	if err != nil {
+		t.Fatal("failed to Reencrypt:", err)
+	}
+
+	previousLines := strings.Split(string(encrypted), "\n")
+	resultLines := strings.Split(string(result), "\n")
+	changed := 0
+	for i := range previousLines {
+		if previousLines[i] != resultLines[i] {
+			changed++
+		}
+	}
+
+	if changed != 1 {
+		t.Errorf("expected one element to change, got:\n%s", result)
+	}
+}
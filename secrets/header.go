@@ -1,5 +1,7 @@
 package secrets
 
+import "encoding/json"
+
 // Wrapper allows access to the echl Header entry
 type Wrapper struct {
 	Header Header `hcl:"eh"`
@@ -9,10 +11,22 @@ type Wrapper struct {
 type Header struct {
 	Encrypted bool
 	Key       string
+	Cipher    string
+	Compact   bool
+	Syntax    string
 
 	Service ServiceParams
 	Protect []string
 	Include []string
+
+	// IncludeBlocks are the includes with options, written as blocks or as objects in the include list. They are read after the paths in Include.
+	IncludeBlocks []Include
+
+	// IncludeMissing selects what happens when an include, a glob or a directory has no files: "error" (the default) or "ignore".
+	IncludeMissing string
+
+	// Merge selects which value is used when a value is both in the contents and in an included fragment: "last" (the default), "first" or "error".
+	Merge string
 }
 
 // ServiceParams is a part of the header entry with crypto service type and parameters
@@ -21,3 +35,44 @@ type ServiceParams struct {
 	Region    string
 	MasterKey string
 }
+
+// Include is an include block in the header, with the path of the included file and its options.
+type Include struct {
+	Path string
+
+	// Optional skips the include if its local files do not exist.
+	Optional bool
+
+	// When is a condition for the include, such as "env:APP_ENV=prod".
+	When string
+}
+
+// UnmarshalJSON decodes the header. The include list can have both paths and objects, which are decoded into Include and IncludeBlocks.
+func (h *Header) UnmarshalJSON(data []byte) error {
+	type header Header
+	var wrapper struct {
+		*header
+		Include []json.RawMessage
+	}
+
+	wrapper.header = (*header)(h)
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+
+	for _, raw := range wrapper.Include {
+		var path string
+		if err := json.Unmarshal(raw, &path); err == nil {
+			h.Include = append(h.Include, path)
+			continue
+		}
+
+		var include Include
+		if err := json.Unmarshal(raw, &include); err != nil {
+			return err
+		}
+		h.IncludeBlocks = append(h.IncludeBlocks, include)
+	}
+
+	return nil
+}
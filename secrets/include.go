@@ -0,0 +1,538 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Values of the merge element in the header.
+const (
+	// mergeLast uses the value of the last included fragment, so fragments can override the values before them.
+	mergeLast = "last"
+
+	// mergeFirst keeps the first value, so the contents wins over its fragments and fragments only supply defaults.
+	mergeFirst = "first"
+
+	// mergeError fails if a value is in more than one place.
+	mergeError = "error"
+)
+
+// includeMerge resolves the values that are both in the contents and in included fragments.
+type includeMerge struct {
+	mode      string
+	conflicts []string
+}
+
+func newIncludeMerge(mode string) (*includeMerge, error) {
+	switch mode {
+	case "":
+		return &includeMerge{mode: mergeLast}, nil
+	case mergeLast, mergeFirst, mergeError:
+		return &includeMerge{mode: mode}, nil
+	default:
+		return nil, fmt.Errorf("unsupported merge: %+q", mode)
+	}
+}
+
+// resolve records the conflict at path and tells if the value of the fragment is used.
+func (m *includeMerge) resolve(path string) bool {
+	m.conflicts = append(m.conflicts, path)
+	return m.mode == mergeLast
+}
+
+// included is the decrypted document at a url merged with its included fragments. Fragments that cannot be merged are kept as text after the document.
+type included struct {
+	format string
+	doc    Document
+	parts  [][]byte
+
+	// conflicts are the values that are both in a file and in its included fragments, as "path in url"
+	conflicts []string
+
+	// sources are the url, the included files and the overlay as they were read, if they are kept to find the values that cannot be decoded
+	sources []includeSource
+}
+
+// includeSource is a file without its header, before the files it includes are merged into it.
+type includeSource struct {
+	url string
+	doc Document
+}
+
+// Values of the includeMissing element in the header.
+const (
+	// missingError fails if an include has no files.
+	missingError = "error"
+
+	// missingIgnore skips includes without files, so a directory of fragments can be empty.
+	missingIgnore = "ignore"
+)
+
+// maxIncludeDepth is the maximum number of nested includes.
+const maxIncludeDepth = 16
+
+// includeReader reads a url and the fragments it includes. Every url is read once, so a fragment that is included by several others is only merged the first time.
+type includeReader struct {
+	ctx       context.Context
+	options   ReadOptions
+	seen      map[string]bool
+	conflicts []string
+
+	// keepSources keeps a copy of every file in sources
+	keepSources bool
+	sources     []includeSource
+}
+
+// readIncludes returns the decrypted document at the url without its header, with all included fragments merged into it in order. Fragments must have the same format. If the options have an environment, its overlay is merged last and overrides all other values. With keepSources, the result also has the url, the included files and the overlay as they were read.
+func readIncludes(ctx context.Context, url string, options ReadOptions, keepSources bool) (*included, error) {
+	r := &includeReader{ctx: ctx, options: options, seen: map[string]bool{}, keepSources: keepSources}
+	result, err := r.read(url, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Env != "" {
+		if err := r.mergeOverlay(result, url, options.Env); err != nil {
+			result.wipe()
+			return nil, err
+		}
+	}
+
+	result.conflicts, result.sources = r.conflicts, r.sources
+	return result, nil
+}
+
+// mergeOverlay merges the overlay of the environment for the url into the result. Overlays are optional, so that environments without their own values can use the base file.
+func (r *includeReader) mergeOverlay(result *included, url, env string) error {
+	name, err := overlayName(url, env)
+	if err != nil {
+		return err
+	}
+
+	locations, err := expandInclude(url, name, true)
+	if err != nil || len(locations) == 0 {
+		return err
+	}
+
+	overlay, err := r.read(locations[0], nil, true)
+	if err == nil && overlay == nil {
+		return nil
+	}
+	if err == nil {
+		err = result.merge(overlay, func(string) bool { return true })
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read overlay %q of environment %q", name, env)
+	}
+
+	return nil
+}
+
+// overlayName returns the name of the overlay of the environment for the url, relative to it: the overlay of config.hcl for "prod" is config.prod.hcl, and the one of .env is .env.prod.
+func overlayName(url, env string) (string, error) {
+	for _, r := range env {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "", fmt.Errorf("invalid environment name: %+q", env)
+		}
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse url %q", url)
+	}
+
+	name, query := path.Base(filepath.ToSlash(strings.TrimPrefix(url, "file://"))), ""
+	if u.Scheme != "" && u.Scheme != "file" {
+		name, query = path.Base(u.Path), u.RawQuery
+	}
+
+	ext := path.Ext(name)
+	if ext == name {
+		ext = ""
+	}
+
+	name = "./" + strings.TrimSuffix(name, ext) + "." + env + ext
+	if query != "" {
+		name += "?" + query
+	}
+
+	return name, nil
+}
+
+// read reads the url, which is included by the urls in chain. It returns nil if the url has already been read, or if it is optional and does not exist.
+func (r *includeReader) read(url string, chain []string, optional bool) (*included, error) {
+	canonical, err := canonicalURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range chain {
+		if u == canonical {
+			return nil, fmt.Errorf("failed, include cycle: %s", strings.Join(append(chain, canonical), " -> "))
+		}
+	}
+
+	if len(chain) > maxIncludeDepth {
+		return nil, fmt.Errorf("failed, more than %d nested includes: %s", maxIncludeDepth, strings.Join(append(chain, canonical), " -> "))
+	}
+
+	if r.seen[canonical] {
+		return nil, nil
+	}
+	r.seen[canonical] = true
+	chain = append(chain, canonical)
+
+	contents, err := readURL(r.ctx, url)
+	if err != nil && optional && isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	format, doc, header, err := decryptWithHeader(r.ctx, url, contents, false)
+	wipe(contents)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt url %q", url)
+	}
+
+	if r.options.Interpolate {
+		doc, err = interpolate(format, doc, r.options.Strict)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read url %q", url)
+		}
+	}
+
+	if r.keepSources {
+		if err := r.keepSource(url, format, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := doc.RemoveHeader(); err != nil {
+		return nil, errors.Wrapf(err, "failed to remove header of url %q", url)
+	}
+
+	merge, err := newIncludeMerge(header.Merge)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read url %q", url)
+	}
+
+	locations, err := includeLocations(url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &included{format: format.Name(), doc: doc}
+	for _, location := range locations {
+		fragment, err := r.read(location.url, chain, location.optional)
+		if err == nil && fragment == nil {
+			continue
+		}
+		if err == nil {
+			err = result.merge(fragment, merge.resolve)
+		}
+		if err != nil {
+			result.wipe()
+			return nil, errors.Wrapf(err, "failed to include %q", location.url)
+		}
+	}
+
+	if merge.mode == mergeError && len(merge.conflicts) > 0 {
+		result.wipe()
+		return nil, fmt.Errorf("failed, values of url %q are also in included files: %s", url, strings.Join(merge.conflicts, ", "))
+	}
+
+	for _, path := range merge.conflicts {
+		r.conflicts = append(r.conflicts, fmt.Sprintf("%s in %s", path, url))
+	}
+
+	return result, nil
+}
+
+// keepSource adds a copy of the document, which is merged with other files later, to the sources.
+func (r *includeReader) keepSource(url string, format Format, doc Document) error {
+	text, err := doc.Bytes()
+	if err != nil {
+		return err
+	}
+
+	source, err := format.Parse(text)
+	wipe(text)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read url %q", url)
+	}
+
+	if err := source.RemoveHeader(); err != nil {
+		return errors.Wrapf(err, "failed to remove header of url %q", url)
+	}
+
+	r.sources = append(r.sources, includeSource{url: url, doc: source})
+	return nil
+}
+
+// includeLocation is a file or url to include. It is skipped if it is optional and does not exist.
+type includeLocation struct {
+	url      string
+	optional bool
+}
+
+// includeLocations returns the locations of the includes in the header of the url whose conditions are met, the paths first and then the blocks, in order.
+func includeLocations(url string, header *Header) ([]includeLocation, error) {
+	switch header.IncludeMissing {
+	case "", missingError, missingIgnore:
+	default:
+		return nil, fmt.Errorf("failed to read url %q: unsupported includeMissing: %+q", url, header.IncludeMissing)
+	}
+
+	includes := make([]Include, 0, len(header.Include)+len(header.IncludeBlocks))
+	for _, path := range header.Include {
+		includes = append(includes, Include{Path: path})
+	}
+
+	var result []includeLocation
+	for _, include := range append(includes, header.IncludeBlocks...) {
+		enabled, err := includeEnabled(include.When)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to include %q", include.Path)
+		}
+
+		if !enabled {
+			continue
+		}
+
+		optional := include.Optional || header.IncludeMissing == missingIgnore
+		locations, err := expandInclude(url, include.Path, optional)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to include %q", include.Path)
+		}
+
+		for _, location := range locations {
+			result = append(result, includeLocation{url: location, optional: optional})
+		}
+	}
+
+	return result, nil
+}
+
+// includeEnabled tells if the condition of an include is met. The conditions are "env:NAME=value", "env:NAME!=value" and "env:NAME", which is met if the environment variable is not empty.
+func includeEnabled(when string) (bool, error) {
+	if when == "" {
+		return true, nil
+	}
+
+	if !strings.HasPrefix(when, "env:") {
+		return false, fmt.Errorf("unsupported condition: %+q", when)
+	}
+
+	condition := strings.TrimPrefix(when, "env:")
+	if i := strings.Index(condition, "!="); i >= 0 {
+		return os.Getenv(condition[:i]) != condition[i+2:], nil
+	}
+
+	if i := strings.Index(condition, "="); i >= 0 {
+		return os.Getenv(condition[:i]) == condition[i+1:], nil
+	}
+
+	return os.Getenv(condition) != "", nil
+}
+
+// expandInclude returns the locations of an include relative to the url that includes it. Local includes can be globs, such as ./conf.d/*.hcl, or directories, which stand for the files in them, and are expanded in sorted order without hidden files. If a local include has no files, expandInclude fails unless ignoreMissing is set.
+func expandInclude(base, name string, ignoreMissing bool) ([]string, error) {
+	location, err := resolveInclude(base, name)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := neturl.Parse(location)
+	if err != nil || (u.Scheme != "" && u.Scheme != "file") {
+		// only local files can be listed
+		return []string{location}, nil
+	}
+
+	name = strings.TrimPrefix(location, "file://")
+	var matches []string
+	if strings.ContainsAny(name, "*?[") {
+		matches, err = filepath.Glob(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to match %q", name)
+		}
+
+		// as in a shell, hidden files are only matched by patterns that start with a dot
+		hidden := strings.HasPrefix(filepath.Base(name), ".")
+		files := matches[:0]
+		for _, match := range matches {
+			if strings.HasPrefix(filepath.Base(match), ".") && !hidden {
+				continue
+			}
+
+			if info, err := os.Stat(match); err != nil || !info.IsDir() {
+				files = append(files, match)
+			}
+		}
+		matches = files
+	} else if info, err := os.Stat(name); os.IsNotExist(err) {
+		matches = nil
+	} else if err == nil && info.IsDir() {
+		entries, err := ioutil.ReadDir(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read directory %q", name)
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				matches = append(matches, filepath.Join(name, entry.Name()))
+			}
+		}
+	} else {
+		matches = []string{name}
+	}
+
+	if len(matches) == 0 {
+		if ignoreMissing {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed, no files match %q", name)
+	}
+
+	sort.Strings(matches)
+	if u.Scheme == "file" {
+		for i, match := range matches {
+			matches[i] = fileURL(match)
+		}
+	}
+
+	return matches, nil
+}
+
+// resolveInclude returns the location of an include relative to the url that includes it. URLs with a scheme and absolute paths are used as they are. Other names are relative to the directory of the url, like links in a web page, so they can start with "./" or "../" and have a query string.
+func resolveInclude(base, name string) (string, error) {
+	ref, err := neturl.Parse(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse include %q", name)
+	}
+
+	if ref.Scheme != "" {
+		return name, nil
+	}
+
+	u, err := neturl.Parse(base)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse url %q", base)
+	}
+
+	if u.Scheme == "" || u.Scheme == "file" {
+		// Local paths are joined as paths, because file URLs such as file://./config.hcl are relative.
+		dir := filepath.Dir(strings.TrimPrefix(base, "file://"))
+		if filepath.IsAbs(filepath.FromSlash(name)) {
+			dir = ""
+		}
+
+		location := filepath.Join(dir, filepath.FromSlash(name))
+		if u.Scheme == "file" {
+			location = fileURL(location)
+		}
+
+		return location, nil
+	}
+
+	return u.ResolveReference(ref).String(), nil
+}
+
+// fileURL returns the file:// url of a local path. Relative paths start with "./" or "../", so that they are not read as a host.
+func fileURL(name string) string {
+	name = filepath.ToSlash(name)
+	if !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../") {
+		name = "./" + name
+	}
+
+	return "file://" + name
+}
+
+// canonicalURL returns the url in a form that is the same for all urls of a file: local paths become absolute file:// urls, and the scheme and host of other urls are lowercase and their path is cleaned.
+func canonicalURL(rawurl string) (string, error) {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse url %q", rawurl)
+	}
+
+	if u.Scheme == "" || u.Scheme == "file" {
+		name := rawurl
+		if u.Scheme == "file" {
+			name = strings.TrimPrefix(rawurl, "file://")
+		}
+
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to resolve path %q", name)
+		}
+
+		return "file://" + filepath.ToSlash(abs), nil
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if u.Path != "" {
+		u.Path = path.Clean(u.Path)
+	}
+
+	return u.String(), nil
+}
+
+// merge merges the fragment into the document, or adds its text after the document if it cannot be merged. The fragment must have the same format.
+func (r *included) merge(fragment *included, resolve func(string) bool) error {
+	if fragment.format != r.format {
+		fragment.wipe()
+		return fmt.Errorf("cannot include %s contents in %s contents", fragment.format, r.format)
+	}
+
+	if doc, ok := r.doc.(MergeableDocument); ok && len(r.parts) == 0 {
+		err := doc.Merge(fragment.doc, resolve)
+		if err == nil {
+			r.parts = fragment.parts
+			return nil
+		}
+
+		if err != errNotMergeable {
+			fragment.wipe()
+			return errors.Wrap(err, "failed to merge")
+		}
+	}
+
+	text, err := fragment.doc.Bytes()
+	if err != nil {
+		fragment.wipe()
+		return err
+	}
+
+	r.parts = append(r.parts, []byte("\n"), text)
+	r.parts = append(r.parts, fragment.parts...)
+	return nil
+}
+
+// bytes returns the text of the document followed by the fragments that were not merged, and wipes the fragments.
+func (r *included) bytes() ([]byte, error) {
+	text, err := r.doc.Bytes()
+	if err != nil {
+		r.wipe()
+		return nil, err
+	}
+
+	return join(append([][]byte{text}, r.parts...)), nil
+}
+
+func (r *included) wipe() {
+	for _, part := range r.parts {
+		wipe(part)
+	}
+}
@@ -0,0 +1,559 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl"
+)
+
+// writeFiles writes the files into a new temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "eh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+const mergeMain = `eh {
+	encrypted = false
+	key = ""
+	service {
+		type = "local"
+	}
+	include = ["./defaults.hcl"]
+	merge = "MERGE"
+}
+
+name = "app"
+
+db {
+	host = "db.example.com"
+	pool {
+		size = 10
+	}
+}
+`
+
+const mergeDefaults = `eh {
+	encrypted = false
+	key = ""
+	service {
+		type = "local"
+	}
+}
+
+name = "default"
+
+db {
+	host = "localhost"
+	port = 5432
+	pool {
+		idle = 2
+	}
+}
+
+cache {
+	ttl = 60
+}
+`
+
+func TestReadMergesIncludes(t *testing.T) {
+	type config struct {
+		Name string `hcl:"name"`
+		DB   struct {
+			Host string `hcl:"host"`
+			Port int    `hcl:"port"`
+			Pool struct {
+				Size int `hcl:"size"`
+				Idle int `hcl:"idle"`
+			} `hcl:"pool"`
+		} `hcl:"db"`
+		Cache struct {
+			TTL int `hcl:"ttl"`
+		} `hcl:"cache"`
+	}
+
+	for _, test := range []struct {
+		merge, name, host string
+	}{
+		{"last", "default", "localhost"},
+		{"first", "app", "db.example.com"},
+	} {
+		dir := writeFiles(t, map[string]string{
+			"main.hcl":     strings.Replace(mergeMain, "MERGE", test.merge, 1),
+			"defaults.hcl": mergeDefaults,
+		})
+		defer os.RemoveAll(dir)
+
+		contents, err := Read(filepath.Join(dir, "main.hcl"))
+		if err != nil {
+			t.Fatalf("failed to Read with merge %q: %v", test.merge, err)
+		}
+
+		var cfg config
+		if err := hcl.Decode(&cfg, string(contents)); err != nil {
+			t.Fatalf("failed to decode merged contents: %v\n%s", err, contents)
+		}
+
+		if strings.Count(string(contents), "db {") != 1 || strings.Count(string(contents), "pool {") != 1 {
+			t.Errorf("expected blocks to be merged, got:\n%s", contents)
+		}
+
+		if cfg.Name != test.name || cfg.DB.Host != test.host {
+			t.Errorf("unexpected values with merge %q:\n%s", test.merge, contents)
+		}
+
+		if cfg.DB.Port != 5432 || cfg.DB.Pool.Size != 10 || cfg.DB.Pool.Idle != 2 || cfg.Cache.TTL != 60 {
+			t.Errorf("expected defaults to be merged with merge %q, got:\n%s", test.merge, contents)
+		}
+	}
+}
+
+func TestReadReportsConflictingIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.hcl":     strings.Replace(mergeMain, "MERGE", "error", 1),
+		"defaults.hcl": mergeDefaults,
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := Read(filepath.Join(dir, "main.hcl"))
+	if err == nil || !strings.Contains(err.Error(), "name, db.host") {
+		t.Errorf("expected conflicts name and db.host, got %v", err)
+	}
+}
+
+func TestReadWithReportListsConflicts(t *testing.T) {
+	for _, merge := range []string{"last", "first"} {
+		dir := writeFiles(t, map[string]string{
+			"main.hcl":     strings.Replace(mergeMain, "MERGE", merge, 1),
+			"defaults.hcl": mergeDefaults,
+		})
+		defer os.RemoveAll(dir)
+
+		url := filepath.Join(dir, "main.hcl")
+		_, report, err := ReadWithReport(context.Background(), url, ReadOptions{})
+		if err != nil {
+			t.Fatalf("failed to ReadWithReport with merge %q: %v", merge, err)
+		}
+
+		expected := []string{"name in " + url, "db.host in " + url}
+		if !reflect.DeepEqual(report.Conflicts, expected) {
+			t.Errorf("expected conflicts %v with merge %q, got %v", expected, merge, report.Conflicts)
+		}
+	}
+}
+
+func TestReadMergesIncludesOfAllFormats(t *testing.T) {
+	for _, test := range []struct {
+		name, main, fragment string
+		expected             []string
+	}{
+		{
+			name:     "config.hcl",
+			main:     "eh {\n  encrypted = false\n  key = \"\"\n  syntax = \"hcl2\"\n  service {\n    type = \"local\"\n  }\n  include = [\"./fragment\"]\n}\n\ndb {\n  host = \"db\"\n}\n",
+			fragment: "eh {\n  encrypted = false\n  key = \"\"\n  syntax = \"hcl2\"\n  service {\n    type = \"local\"\n  }\n}\n\ndb {\n  host = \"localhost\"\n  port = 5432\n}\n",
+			expected: []string{`host = "localhost"`, "port = 5432"},
+		},
+		{
+			name:     "config.json",
+			main:     `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}, "include": ["./fragment"]}, "db": {"host": "db"}}`,
+			fragment: `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}}, "db": {"host": "localhost", "port": 5432}}`,
+			expected: []string{`"db": {"host": "localhost", "port": 5432}`},
+		},
+		{
+			name:     "config.yaml",
+			main:     "eh:\n  encrypted: false\n  key: \"\"\n  service:\n    type: local\n  include: [./fragment]\ndb:\n  host: db\n",
+			fragment: "eh:\n  encrypted: false\n  key: \"\"\n  service:\n    type: local\ndb:\n  host: localhost\n  port: 5432\n",
+			expected: []string{"db:\n  host: localhost\n  port: 5432\n"},
+		},
+		{
+			name:     "config.toml",
+			main:     "[eh]\nencrypted = false\nkey = \"\"\ninclude = [\"./fragment\"]\n[eh.service]\ntype = \"local\"\n\n[db]\nhost = \"db\"\n",
+			fragment: "[eh]\nencrypted = false\nkey = \"\"\n[eh.service]\ntype = \"local\"\n\n[db]\nhost = \"localhost\"\nport = 5432\n",
+			expected: []string{"[db]\nhost = 'localhost'\nport = 5432\n"},
+		},
+		{
+			name:     "config.env",
+			main:     "#eh encrypted = false\n#eh key = \"\"\n#eh service { type = \"local\" }\n#eh include = [\"./fragment\"]\nDB_HOST=db\n",
+			fragment: "#eh encrypted = false\n#eh key = \"\"\n#eh service { type = \"local\" }\nDB_HOST=localhost\nDB_PORT=5432\n",
+			expected: []string{"DB_HOST=localhost\nDB_PORT=5432\n"},
+		},
+	} {
+		dir := writeFiles(t, map[string]string{test.name: test.main, "fragment": test.fragment})
+		defer os.RemoveAll(dir)
+
+		contents, err := Read(filepath.Join(dir, test.name))
+		if err != nil {
+			t.Errorf("failed to Read %s: %v", test.name, err)
+			continue
+		}
+
+		for _, expected := range test.expected {
+			if !strings.Contains(string(contents), expected) {
+				t.Errorf("expected %s to contain %q, got:\n%s", test.name, expected, contents)
+			}
+		}
+	}
+}
+
+// includeFile returns HCL contents that include the files and set the value.
+func includeFile(value string, includes ...string) string {
+	return fmt.Sprintf("eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n\tinclude = [%s]\n\tmerge = \"error\"\n}\n\n%s = true\n", strings.Join(includes, ", "), value)
+}
+
+func TestReadRejectsIncludeCycles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.hcl": includeFile("a", `"./b.hcl"`),
+		"b.hcl": includeFile("b", `"./c.hcl"`),
+		"c.hcl": includeFile("c", `"./a.hcl"`),
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := Read(filepath.Join(dir, "a.hcl"))
+	if err == nil || !strings.Contains(err.Error(), "a.hcl -> file://") || !strings.Contains(err.Error(), "c.hcl -> file://"+filepath.ToSlash(dir)+"/a.hcl") {
+		t.Errorf("expected include cycle error, got %v", err)
+	}
+}
+
+func TestReadIncludesSharedFragmentsOnce(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.hcl":      includeFile("a", `"./b.hcl"`, `"./c.hcl"`),
+		"b.hcl":      includeFile("b", `"./shared.hcl"`),
+		"c.hcl":      includeFile("c", `"./shared.hcl"`),
+		"shared.hcl": includeFile("shared"),
+	})
+	defer os.RemoveAll(dir)
+
+	contents, err := Read(filepath.Join(dir, "a.hcl"))
+	if err != nil {
+		t.Fatalf("failed to Read: %v", err)
+	}
+
+	if strings.Count(string(contents), "shared = true") != 1 {
+		t.Errorf("expected shared.hcl to be included once, got:\n%s", contents)
+	}
+}
+
+func TestReadLimitsIncludeDepth(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i <= maxIncludeDepth+1; i++ {
+		files[fmt.Sprintf("%d.hcl", i)] = includeFile(fmt.Sprintf("v%d", i), fmt.Sprintf(`"./%d.hcl"`, i+1))
+	}
+	files[fmt.Sprintf("%d.hcl", maxIncludeDepth+2)] = includeFile("last")
+
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+
+	_, err := Read(filepath.Join(dir, "0.hcl"))
+	if err == nil || !strings.Contains(err.Error(), "nested includes") {
+		t.Errorf("expected nested includes error, got %v", err)
+	}
+
+	_, err = Read(filepath.Join(dir, "2.hcl"))
+	if err != nil {
+		t.Errorf("failed to Read %d nested includes: %v", maxIncludeDepth, err)
+	}
+}
+
+func TestResolveInclude(t *testing.T) {
+	for _, test := range []struct {
+		base, name, expected string
+	}{
+		{"config.hcl", "./shared.hcl", "shared.hcl"},
+		{"config.hcl", "shared.hcl", "shared.hcl"},
+		{"/etc/app/config.hcl", "./shared.hcl", "/etc/app/shared.hcl"},
+		{"/etc/app/config.hcl", "../shared.hcl", "/etc/shared.hcl"},
+		{"/etc/app/config.hcl", "conf/db.hcl", "/etc/app/conf/db.hcl"},
+		{"/etc/app/config.hcl", "/opt/shared.hcl", "/opt/shared.hcl"},
+		{"file:///etc/app/config.hcl", "./shared.hcl", "file:///etc/app/shared.hcl"},
+		{"file://./config.hcl", "./shared.hcl", "file://./shared.hcl"},
+		{"file://./app/config.hcl", "../shared.hcl", "file://./shared.hcl"},
+		{"file://../config.hcl", "shared.hcl", "file://../shared.hcl"},
+		{"https://example.com/app/config.hcl?version=2", "./shared.hcl", "https://example.com/app/shared.hcl"},
+		{"https://example.com/app/config.hcl", "../shared.hcl?version=3", "https://example.com/shared.hcl?version=3"},
+		{"s3://bucket/app/config.hcl", "shared.hcl", "s3://bucket/app/shared.hcl"},
+		{"s3://bucket/app/config.hcl", "https://example.com/shared.hcl", "https://example.com/shared.hcl"},
+	} {
+		location, err := resolveInclude(test.base, test.name)
+		if err != nil {
+			t.Errorf("failed to resolve %q in %q: %v", test.name, test.base, err)
+		} else if location != filepath.FromSlash(test.expected) && location != test.expected {
+			t.Errorf("expected %q in %q to be %q, got %q", test.name, test.base, test.expected, location)
+		}
+	}
+}
+
+func TestReadNestedIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app/main.hcl":         includeFile("main", `"conf/db.hcl"`, `"../common/base.hcl"`),
+		"app/conf/db.hcl":      includeFile("db", `"./local.hcl"`, `"../../common/base.hcl"`),
+		"app/conf/local.hcl":   includeFile("local"),
+		"common/base.hcl":      includeFile("base", `"sub/extra.hcl"`),
+		"common/sub/extra.hcl": includeFile("extra"),
+	})
+	defer os.RemoveAll(dir)
+
+	for _, url := range []string{filepath.Join(dir, "app", "main.hcl"), "file://" + filepath.ToSlash(filepath.Join(dir, "app", "main.hcl"))} {
+		contents, err := Read(url)
+		if err != nil {
+			t.Errorf("failed to Read %q: %v", url, err)
+			continue
+		}
+
+		for _, value := range []string{"main", "db", "local", "base", "extra"} {
+			if strings.Count(string(contents), value+" = true") != 1 {
+				t.Errorf("expected %s to be included once, got:\n%s", value, contents)
+			}
+		}
+	}
+}
+
+func TestReadGlobAndDirectoryIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"glob.hcl":            strings.Replace(includeFile("glob", `"./conf.d/*.hcl"`), `merge = "error"`, `merge = "last"`, 1),
+		"dir.hcl":             strings.Replace(includeFile("dir", `"conf.d"`), `merge = "error"`, `merge = "last"`, 1),
+		"conf.d/20-db.hcl":    "eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n}\n\nservice = \"db\"\n",
+		"conf.d/10-cache.hcl": "eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n}\n\nservice = \"cache\"\n",
+		"conf.d/.hidden.hcl":  "not included",
+		"conf.d/sub/30-x.hcl": "not included",
+		"missing.hcl":         includeFile("missing", `"./missing.d/*.hcl"`),
+		"missing-ignored.hcl": strings.Replace(includeFile("missing", `"./missing.d/*.hcl"`, `"./missing.d"`), "\tmerge", "\tincludeMissing = \"ignore\"\n\tmerge", 1),
+		"missing-file.hcl":    includeFile("missing", `"./missing.hcl.d"`),
+		"missing-unknown.hcl": strings.Replace(includeFile("missing"), "\tmerge", "\tincludeMissing = \"maybe\"\n\tmerge", 1),
+	})
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"glob.hcl", "dir.hcl"} {
+		contents, err := Read(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("failed to Read %s: %v", name, err)
+			continue
+		}
+
+		// fragments are merged in sorted order, so the last one wins
+		if !strings.Contains(string(contents), `service = "db"`) || strings.Contains(string(contents), "not included") {
+			t.Errorf("expected %s to include conf.d in order, got:\n%s", name, contents)
+		}
+	}
+
+	for _, name := range []string{"missing.hcl", "missing-file.hcl"} {
+		if _, err := Read(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), "no files match") {
+			t.Errorf("expected %s to fail with no files, got %v", name, err)
+		}
+	}
+
+	if _, err := Read(filepath.Join(dir, "missing-ignored.hcl")); err != nil {
+		t.Errorf("failed to Read with includeMissing: %v", err)
+	}
+
+	if _, err := Read(filepath.Join(dir, "missing-unknown.hcl")); err == nil {
+		t.Error("expected unsupported includeMissing to fail")
+	}
+}
+
+func TestDecodeIncludeBlocks(t *testing.T) {
+	expected := []Include{
+		{Path: "./local", Optional: true},
+		{Path: "./prod", When: "env:APP_ENV=prod"},
+	}
+
+	for _, contents := range []string{
+		"eh {\n\tencrypted = false\n\tinclude = [\"./shared\"]\n\tinclude {\n\t\tpath = \"./local\"\n\t\toptional = true\n\t}\n\tinclude {\n\t\tpath = \"./prod\"\n\t\twhen = \"env:APP_ENV=prod\"\n\t}\n}\n",
+		"eh {\n  syntax = \"hcl2\"\n  include = [\"./shared\"]\n  include {\n    path = \"./local\"\n    optional = true\n  }\n  include {\n    path = \"./prod\"\n    when = \"env:APP_ENV=prod\"\n  }\n}\n",
+		`{"eh": {"include": ["./shared", {"path": "./local", "optional": true}, {"path": "./prod", "when": "env:APP_ENV=prod"}]}}`,
+		"eh:\n  include:\n    - ./shared\n    - path: ./local\n      optional: true\n    - path: ./prod\n      when: env:APP_ENV=prod\n",
+		"[eh]\ninclude = [\"./shared\", {path = \"./local\", optional = true}, {path = \"./prod\", when = \"env:APP_ENV=prod\"}]\n",
+		"#eh include = [\"./shared\", { path = \"./local\", optional = true }]\n#eh include { path = \"./prod\", when = \"env:APP_ENV=prod\" }\nA=1\n",
+	} {
+		_, _, header, err := parseDocument("", []byte(contents))
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", contents, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(header.Include, []string{"./shared"}) || !reflect.DeepEqual(header.IncludeBlocks, expected) {
+			t.Errorf("unexpected includes of %q: %+v %+v", contents, header.Include, header.IncludeBlocks)
+		}
+	}
+}
+
+func TestReadOptionalAndConditionalIncludes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.hcl": strings.Replace(includeFile("main"), "\tmerge", `	include {
+		path = "./local-overrides.hcl"
+		optional = true
+	}
+	include {
+		path = "./prod.hcl"
+		when = "env:EH_TEST_ENV=prod"
+	}
+	include {
+		path = "./dev.hcl"
+		when = "env:EH_TEST_ENV!=prod"
+	}
+	merge`, 1),
+		"prod.hcl":      includeFile("prod"),
+		"dev.hcl":       includeFile("dev"),
+		"condition.hcl": strings.Replace(includeFile("main"), "\tmerge", "\tinclude {\n\t\tpath = \"./prod.hcl\"\n\t\twhen = \"APP_ENV=prod\"\n\t}\n\tmerge", 1),
+	})
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("EH_TEST_ENV")
+
+	for _, env := range []string{"prod", "dev"} {
+		os.Setenv("EH_TEST_ENV", env)
+
+		contents, err := Read(filepath.Join(dir, "main.hcl"))
+		if err != nil {
+			t.Errorf("failed to Read with EH_TEST_ENV=%s: %v", env, err)
+			continue
+		}
+
+		other := map[string]string{"prod": "dev", "dev": "prod"}[env]
+		if !strings.Contains(string(contents), env+" = true") || strings.Contains(string(contents), other+" = true") {
+			t.Errorf("expected only %s.hcl to be included, got:\n%s", env, contents)
+		}
+	}
+
+	if _, err := Read(filepath.Join(dir, "condition.hcl")); err == nil || !strings.Contains(err.Error(), "unsupported condition") {
+		t.Errorf("expected unsupported condition error, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "local-overrides.hcl"), []byte(includeFile("local")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := Read(filepath.Join(dir, "main.hcl"))
+	if err != nil || !strings.Contains(string(contents), "local = true") {
+		t.Errorf("expected optional include to be read, got %v:\n%s", err, contents)
+	}
+}
+
+func TestOverlayName(t *testing.T) {
+	for _, test := range []struct {
+		url, expected string
+	}{
+		{"config.hcl", "./config.prod.hcl"},
+		{"/etc/app/config.json", "./config.prod.json"},
+		{"file://./conf/config.yaml", "./config.prod.yaml"},
+		{"/etc/app/.env", "./.env.prod"},
+		{"/etc/app/config", "./config.prod"},
+		{"https://example.com/app/config.hcl?version=2", "./config.prod.hcl?version=2"},
+		{"s3://bucket/app/config.toml", "./config.prod.toml"},
+	} {
+		name, err := overlayName(test.url, "prod")
+		if err != nil || name != test.expected {
+			t.Errorf("expected overlay of %q to be %q, got %q (%v)", test.url, test.expected, name, err)
+		}
+	}
+
+	if _, err := overlayName("config.hcl", "../prod"); err == nil {
+		t.Error("expected invalid environment name to fail")
+	}
+}
+
+func TestReadWithEnvironmentOverlay(t *testing.T) {
+	overlay, err := Encrypt([]byte("eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n\tprotect = [\"password\"]\n}\n\ndb {\n\tpassword = \"prod-secret\"\n}\n"))
+	if err != nil {
+		t.Fatalf("failed to Encrypt overlay: %v", err)
+	}
+
+	dir := writeFiles(t, map[string]string{
+		"config.hcl":      strings.Replace(includeFile("base", `"./shared.hcl"`), "\n\nbase = true\n", "\n\ndb {\n\thost = \"localhost\"\n\tpassword = \"dev-secret\"\n}\n", 1),
+		"shared.hcl":      includeFile("shared"),
+		"config.prod.hcl": string(overlay),
+	})
+	defer os.RemoveAll(dir)
+
+	url := filepath.Join(dir, "config.hcl")
+	for _, test := range []struct {
+		env, password string
+	}{
+		{"", "dev-secret"},
+		{"dev", "dev-secret"},
+		{"prod", "prod-secret"},
+	} {
+		contents, err := ReadWithOptions(context.Background(), url, ReadOptions{Env: test.env})
+		if err != nil {
+			t.Errorf("failed to Read with env %q: %v", test.env, err)
+			continue
+		}
+
+		if !strings.Contains(string(contents), `"`+test.password+`"`) || !strings.Contains(string(contents), `"localhost"`) || !strings.Contains(string(contents), "shared = true") {
+			t.Errorf("unexpected contents with env %q:\n%s", test.env, contents)
+		}
+	}
+
+	// only the command reads EH_ENV
+	os.Setenv("EH_ENV", "prod")
+	defer os.Unsetenv("EH_ENV")
+
+	contents, err := Read(url)
+	if err != nil || !strings.Contains(string(contents), `"dev-secret"`) {
+		t.Errorf("expected Read to ignore EH_ENV, got %v:\n%s", err, contents)
+	}
+}
+
+func TestReadWithoutOverlayOverHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config.hcl" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, includeFile("base"))
+	}))
+	defer server.Close()
+
+	contents, err := ReadWithOptions(context.Background(), server.URL+"/config.hcl", ReadOptions{Env: "prod"})
+	if err != nil || !strings.Contains(string(contents), "base = true") {
+		t.Errorf("expected missing overlay to be skipped, got %v:\n%s", err, contents)
+	}
+}
+
+func TestReadOptionalIncludeOverHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/optional.hcl":
+			fmt.Fprint(w, strings.Replace(includeFile("main"), "\tmerge", "\tinclude {\n\t\tpath = \"./local.hcl\"\n\t\toptional = true\n\t}\n\tmerge", 1))
+		case "/required.hcl":
+			fmt.Fprint(w, includeFile("main", `"./local.hcl"`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	contents, err := Read(server.URL + "/optional.hcl")
+	if err != nil || !strings.Contains(string(contents), "main = true") {
+		t.Errorf("expected missing optional include to be skipped, got %v:\n%s", err, contents)
+	}
+
+	if _, err := Read(server.URL + "/required.hcl"); err == nil {
+		t.Error("expected missing include to fail")
+	}
+
+	if err := Verify(server.URL+"/optional.hcl", &struct{}{}); err != nil {
+		t.Errorf("expected missing optional include to be skipped by Verify, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// interpolate returns the document with the placeholders in its string values replaced. Values of other types are left as they are. Some formats only keep the decrypted values in the text of the document, so the document is parsed again first.
+func interpolate(format Format, doc Document, strict bool) (Document, error) {
+	text, err := doc.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err = format.Parse(text)
+	wipe(text)
+	if err != nil {
+		return nil, err
+	}
+
+	err = doc.Walk(func(v Value) error {
+		text, valueType, err := v.Get()
+		if _, ok := err.(*UnsupportedError); ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if valueType != ValueString || !strings.Contains(text, "${") {
+			return nil
+		}
+
+		result, err := interpolateString(text, strict)
+		if err != nil {
+			return errors.Wrapf(err, "failed to interpolate %q", v.Path())
+		}
+
+		return v.Set(result, ValueString)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// interpolateString replaces ${env:NAME} with the value of the environment variable and ${file:PATH} with the contents of the file without a trailing newline. $${ stands for ${, and other placeholders, such as ${var.name}, are kept. Unless strict is set, variables that are not set and files that cannot be read are replaced with nothing.
+func interpolateString(text string, strict bool) (string, error) {
+	var result strings.Builder
+	for {
+		start := strings.Index(text, "${")
+		if start < 0 {
+			result.WriteString(text)
+			return result.String(), nil
+		}
+
+		if start > 0 && text[start-1] == '$' {
+			result.WriteString(text[:start-1] + "${")
+			text = text[start+2:]
+			continue
+		}
+
+		end := strings.Index(text[start:], "}")
+		if end < 0 {
+			result.WriteString(text)
+			return result.String(), nil
+		}
+		end += start
+
+		result.WriteString(text[:start])
+		placeholder := text[start+2 : end]
+		text = text[end+1:]
+
+		switch {
+		case strings.HasPrefix(placeholder, "env:"):
+			name := strings.TrimPrefix(placeholder, "env:")
+			value, ok := os.LookupEnv(name)
+			if !ok && strict {
+				return "", fmt.Errorf("failed, environment variable %s is not set", name)
+			}
+			result.WriteString(value)
+
+		case strings.HasPrefix(placeholder, "file:"):
+			name := strings.TrimPrefix(placeholder, "file:")
+			contents, err := ioutil.ReadFile(name)
+			if err != nil && strict {
+				return "", errors.Wrapf(err, "failed to read file %q", name)
+			}
+			value := strings.TrimSuffix(strings.TrimSuffix(string(contents), "\n"), "\r")
+			wipe(contents)
+			result.WriteString(value)
+
+		default:
+			result.WriteString("${" + placeholder + "}")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolateString(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"password": "file-secret\n"})
+	defer os.RemoveAll(dir)
+
+	os.Setenv("EH_TEST_HOST", "db.example.com")
+	defer os.Unsetenv("EH_TEST_HOST")
+
+	password := filepath.Join(dir, "password")
+	for _, test := range []struct {
+		text, expected string
+	}{
+		{"plain", "plain"},
+		{"${env:EH_TEST_HOST}", "db.example.com"},
+		{"postgres://${env:EH_TEST_HOST}:5432/app", "postgres://db.example.com:5432/app"},
+		{"${file:" + password + "}", "file-secret"},
+		{"${env:EH_TEST_HOST}/${file:" + password + "}", "db.example.com/file-secret"},
+		{"$${env:EH_TEST_HOST}", "${env:EH_TEST_HOST}"},
+		{"${var.name}", "${var.name}"},
+		{"${env:EH_TEST_HOST", "${env:EH_TEST_HOST"},
+		{"[${env:EH_TEST_MISSING}]", "[]"},
+		{"[${file:" + password + ".missing}]", "[]"},
+	} {
+		result, err := interpolateString(test.text, false)
+		if err != nil || result != test.expected {
+			t.Errorf("expected %q to be %q, got %q (%v)", test.text, test.expected, result, err)
+		}
+	}
+
+	for _, text := range []string{"${env:EH_TEST_MISSING}", "${file:" + password + ".missing}"} {
+		if _, err := interpolateString(text, true); err == nil {
+			t.Errorf("expected %q to fail in strict mode", text)
+		}
+	}
+}
+
+func TestReadInterpolates(t *testing.T) {
+	os.Setenv("EH_TEST_HOST", "db.example.com")
+	os.Setenv("EH_TEST_PASSWORD", "env-secret")
+	defer os.Unsetenv("EH_TEST_HOST")
+	defer os.Unsetenv("EH_TEST_PASSWORD")
+
+	encrypted, err := Encrypt([]byte("eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n\tprotect = [\"password\"]\n}\n\nhost = \"${env:EH_TEST_HOST}\"\npassword = \"${env:EH_TEST_PASSWORD}\"\nport = 5432\n"))
+	if err != nil {
+		t.Fatalf("failed to Encrypt: %v", err)
+	}
+
+	files := map[string]string{
+		"config.hcl":  string(encrypted),
+		"config.json": `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}}, "host": "${env:EH_TEST_HOST}", "password": "${env:EH_TEST_PASSWORD}"}`,
+		"config.yaml": "eh:\n  encrypted: false\n  key: \"\"\n  service:\n    type: local\nhost: ${env:EH_TEST_HOST}\npassword: \"${env:EH_TEST_PASSWORD}\"\n",
+		"config.toml": "[eh]\nencrypted = false\nkey = \"\"\n[eh.service]\ntype = \"local\"\n\n[db]\nhost = \"${env:EH_TEST_HOST}\"\npassword = \"${env:EH_TEST_PASSWORD}\"\n",
+		"config.env":  "#eh encrypted = false\n#eh key = \"\"\n#eh service { type = \"local\" }\nHOST=${env:EH_TEST_HOST}\nPASSWORD=\"${env:EH_TEST_PASSWORD}\"\n",
+		"config.tf":   "eh {\n  encrypted = false\n  key = \"\"\n  syntax = \"hcl2\"\n  service {\n    type = \"local\"\n  }\n}\n\nhost = \"$${env:EH_TEST_HOST}\"\npassword = \"$${env:EH_TEST_PASSWORD}\"\n",
+		"strict.hcl":  "eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n}\n\nhost = \"${env:EH_TEST_MISSING}\"\n",
+	}
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"config.hcl", "config.tf", "config.json", "config.yaml", "config.toml", "config.env"} {
+		contents, err := ReadWithOptions(context.Background(), filepath.Join(dir, name), ReadOptions{Interpolate: true, Strict: true})
+		if err != nil {
+			t.Errorf("failed to Read %s: %v", name, err)
+			continue
+		}
+
+		if !strings.Contains(string(contents), "db.example.com") || !strings.Contains(string(contents), "env-secret") || strings.Contains(string(contents), "${") {
+			t.Errorf("expected %s to be interpolated, got:\n%s", name, contents)
+		}
+	}
+
+	contents, err := Read(filepath.Join(dir, "config.json"))
+	if err != nil || !strings.Contains(string(contents), "${env:EH_TEST_HOST}") {
+		t.Errorf("expected Read not to interpolate, got %v:\n%s", err, contents)
+	}
+
+	if _, err := ReadWithOptions(context.Background(), filepath.Join(dir, "strict.hcl"), ReadOptions{Interpolate: true, Strict: true}); err == nil || !strings.Contains(err.Error(), "EH_TEST_MISSING") {
+		t.Errorf("expected strict interpolation to fail, got %v", err)
+	}
+}
@@ -0,0 +1,499 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const formatJSON = "json"
+
+// Protected JSON objects are encrypted as their text.
+const valueTypeJSON = "json"
+
+// jsonFormat is the JSON format. It accepts JSON objects.
+type jsonFormat struct{}
+
+func (jsonFormat) Name() string {
+	return formatJSON
+}
+
+func (jsonFormat) Extensions() []string {
+	return []string{".json"}
+}
+
+func (jsonFormat) Detect(contents []byte) bool {
+	trimmed := bytes.TrimSpace(contents)
+	return len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed)
+}
+
+// Parse parses the contents as JSON and decodes its top-level 'eh' object.
+func (jsonFormat) Parse(contents []byte) (Document, error) {
+	var wrapper struct {
+		Header *Header `json:"eh"`
+	}
+
+	if err := json.Unmarshal(contents, &wrapper); err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' object")
+	}
+
+	if wrapper.Header == nil {
+		return nil, errors.New("failed, must have 'eh' object")
+	}
+
+	d, err := parseJSON(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	d.header = wrapper.Header
+	return d, nil
+}
+
+// parseJSON parses a copy of the contents, which may be wiped by the caller.
+func parseJSON(contents []byte) (*jsonDocument, error) {
+	text := append([]byte(nil), contents...)
+
+	p := &jsonParser{text: text}
+	root, err := p.parseValue()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse JSON")
+	}
+
+	if root.kind != '{' {
+		return nil, errors.New("failed, JSON contents must be an object")
+	}
+
+	return &jsonDocument{text: text, root: root}, nil
+}
+
+// jsonDocument edits the original text, which keeps the order of keys and the indentation.
+type jsonDocument struct {
+	text   []byte
+	root   *jsonNode
+	header *Header
+	edits  []textEdit
+}
+
+// jsonNode is a JSON value and its position in the text.
+type jsonNode struct {
+	start, end int
+
+	// kind is the first byte of the value: '{', '[', '"', 't', 'f', 'n' or a number
+	kind byte
+
+	members []*jsonMember
+	items   []*jsonNode
+}
+
+// jsonMember is a member of a JSON object. The start is the position of its key.
+type jsonMember struct {
+	name  string
+	start int
+	value *jsonNode
+}
+
+func (d *jsonDocument) Header() (*Header, error) {
+	return d.header, nil
+}
+
+func (d *jsonDocument) Walk(fn func(Value) error) error {
+	for _, m := range d.root.members {
+		if m.name == "eh" {
+			// do not process eh element
+			continue
+		}
+
+		if err := d.walkNode(fn, []string{m.name}, m.name, m.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkNode walks the node and the values inside it. Array items share the path of the array.
+func (d *jsonDocument) walkNode(fn func(Value) error, names []string, path string, node *jsonNode) error {
+	switch node.kind {
+	case '[':
+		for _, item := range node.items {
+			if err := d.walkNode(fn, names, path, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case 'n':
+		return nil
+	}
+
+	if err := fn(&jsonValue{doc: d, names: names, path: path, node: node}); err != nil {
+		if err == SkipValue {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to process %q", path)
+	}
+
+	for _, m := range node.members {
+		if err := d.walkNode(fn, append(names[:len(names):len(names)], m.name), joinPath(path, m.name), m.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *jsonDocument) replace(node *jsonNode, text []byte) {
+	d.edits = append(d.edits, textEdit{start: node.start, end: node.end, text: text})
+}
+
+// headerNode returns the 'eh' object.
+func (d *jsonDocument) headerNode() (*jsonNode, error) {
+	for _, m := range d.root.members {
+		if m.name == "eh" && m.value.kind == '{' {
+			return m.value, nil
+		}
+	}
+
+	return nil, errors.New("failed, must have 'eh' object")
+}
+
+func (d *jsonDocument) SetHeaderValue(name string, value interface{}) error {
+	header, err := d.headerNode()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range header.members {
+		if m.name == name {
+			text, err := json.Marshal(value)
+			if err != nil {
+				return errors.Wrapf(err, "failed to encode %q", name)
+			}
+
+			d.replace(m.value, text)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed, no %q element found in 'eh'", name)
+}
+
+func (d *jsonDocument) RemoveHeader() error {
+	members := d.root.members
+	for i, m := range members {
+		if m.name != "eh" {
+			continue
+		}
+
+		// remove the member together with the comma that separates it from its neighbour
+		removal := textEdit{start: m.start, end: m.value.end}
+		switch {
+		case i+1 < len(members):
+			removal.end = members[i+1].start
+		case i > 0:
+			removal.start = members[i-1].value.end
+		}
+
+		// earlier changes to the header are removed with it
+		d.edits = removeEdits(d.edits, []textEdit{removal})
+	}
+
+	return nil
+}
+
+func (d *jsonDocument) Bytes() ([]byte, error) {
+	return applyEdits(d.text, d.edits)
+}
+
+// Decode decodes the document with encoding/json.
+func (d *jsonDocument) Decode(out interface{}) error {
+	text, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(text)
+
+	return decodeJSON(text, out)
+}
+
+// decodeJSON decodes the text with encoding/json and returns a DecodeError with the path of a value of the wrong type.
+func decodeJSON(text []byte, out interface{}) error {
+	err := json.Unmarshal(text, out)
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+		return &DecodeError{Path: typeErr.Field, Err: err}
+	}
+
+	return err
+}
+
+// Merge merges the members of the fragment into the document.
+func (d *jsonDocument) Merge(fragment Document, resolve func(path string) bool) error {
+	f, ok := fragment.(*jsonDocument)
+	if !ok {
+		return errNotMergeable
+	}
+
+	text, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(text)
+
+	fragmentText, err := f.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(fragmentText)
+
+	merged, err := parseJSON(text)
+	if err != nil {
+		return err
+	}
+
+	parsedFragment, err := parseJSON(fragmentText)
+	if err != nil {
+		return err
+	}
+
+	merged.mergeObject(merged.root, parsedFragment, parsedFragment.root, "", resolve)
+
+	// the merged text is parsed again, so that the values can be walked
+	mergedText, err := merged.Bytes()
+	wipe(merged.text)
+	if err != nil {
+		return err
+	}
+	defer wipe(mergedText)
+
+	result, err := parseJSON(mergedText)
+	if err != nil {
+		return err
+	}
+
+	d.text, d.root, d.edits = result.text, result.root, nil
+	return nil
+}
+
+// mergeObject adds edits that merge the members of the fragment object into the object.
+func (d *jsonDocument) mergeObject(obj *jsonNode, fragment *jsonDocument, fragmentObj *jsonNode, path string, resolve func(path string) bool) {
+	for _, m := range fragmentObj.members {
+		raw := fragment.text[m.value.start:m.value.end]
+		memberPath := joinPath(path, m.name)
+
+		var existing *jsonMember
+		for _, member := range obj.members {
+			if member.name == m.name {
+				existing = member
+			}
+		}
+
+		switch {
+		case existing == nil:
+			member := append(jsonString(m.name), ": "...)
+			member = append(member, raw...)
+
+			if len(obj.members) == 0 {
+				d.edits = append(d.edits, textEdit{start: obj.start + 1, end: obj.start + 1, text: member})
+				obj.members = append(obj.members, &jsonMember{name: m.name, start: obj.start + 1, value: &jsonNode{start: obj.start + 1, end: obj.start + 1}})
+				continue
+			}
+
+			last := obj.members[len(obj.members)-1]
+			lineStart := bytes.LastIndexByte(d.text[:last.start], '\n') + 1
+			indent := d.text[lineStart:last.start]
+			if len(bytes.TrimSpace(indent)) > 0 {
+				indent = []byte(" ")
+			} else {
+				indent = append([]byte("\n"), indent...)
+			}
+
+			d.edits = append(d.edits, textEdit{start: last.value.end, end: last.value.end, text: append(append([]byte(","), indent...), member...)})
+		case existing.value.kind == '{' && m.value.kind == '{':
+			d.mergeObject(existing.value, fragment, m.value, memberPath, resolve)
+		case !bytes.Equal(d.text[existing.value.start:existing.value.end], raw) && resolve(memberPath):
+			d.replace(existing.value, append([]byte(nil), raw...))
+		}
+	}
+}
+
+// jsonValue is a string, number, boolean or object in a JSON document.
+type jsonValue struct {
+	doc   *jsonDocument
+	names []string
+	path  string
+	node  *jsonNode
+}
+
+func (v *jsonValue) Names() []string {
+	return v.names
+}
+
+func (v *jsonValue) Path() string {
+	return v.path
+}
+
+func (v *jsonValue) Get() (string, string, error) {
+	raw := v.doc.text[v.node.start:v.node.end]
+
+	switch v.node.kind {
+	case '"':
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", "", errors.Wrapf(err, "failed to decode %q", v.path)
+		}
+		return value, ValueString, nil
+	case '{':
+		return string(raw), valueTypeJSON, nil
+	case 't', 'f':
+		return string(raw), ValueBool, nil
+	default:
+		return string(raw), ValueNumber, nil
+	}
+}
+
+func (v *jsonValue) Set(text string, valueType string) error {
+	if valueType == ValueString {
+		v.doc.replace(v.node, jsonString(text))
+	} else {
+		v.doc.replace(v.node, []byte(text))
+	}
+
+	return nil
+}
+
+// jsonString returns the JSON text of the string without escaping HTML characters.
+func jsonString(s string) []byte {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+// jsonParser records the positions of the values in a valid JSON text.
+type jsonParser struct {
+	text []byte
+	pos  int
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.text) {
+		switch p.text[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) parseValue() (*jsonNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return nil, errors.New("unexpected end of JSON")
+	}
+
+	node := &jsonNode{start: p.pos, kind: p.text[p.pos]}
+
+	switch node.kind {
+	case '{':
+		p.pos++
+		for {
+			p.skipSpace()
+			if p.pos < len(p.text) && p.text[p.pos] == '}' {
+				p.pos++
+				break
+			}
+
+			start := p.pos
+			if err := p.skipString(); err != nil {
+				return nil, err
+			}
+
+			var name string
+			if err := json.Unmarshal(p.text[start:p.pos], &name); err != nil {
+				return nil, errors.Wrapf(err, "invalid key at %d", start)
+			}
+
+			p.skipSpace()
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+
+			node.members = append(node.members, &jsonMember{name: name, start: start, value: value})
+
+			p.skipSpace()
+			if p.pos < len(p.text) && p.text[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	case '[':
+		p.pos++
+		for {
+			p.skipSpace()
+			if p.pos < len(p.text) && p.text[p.pos] == ']' {
+				p.pos++
+				break
+			}
+
+			item, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+
+			node.items = append(node.items, item)
+
+			p.skipSpace()
+			if p.pos < len(p.text) && p.text[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	case '"':
+		if err := p.skipString(); err != nil {
+			return nil, err
+		}
+	default:
+		for p.pos < len(p.text) && bytes.IndexByte([]byte(" \t\r\n,]}"), p.text[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+
+	node.end = p.pos
+	return node, nil
+}
+
+func (p *jsonParser) skipString() error {
+	if err := p.expect('"'); err != nil {
+		return err
+	}
+
+	for p.pos < len(p.text) {
+		switch p.text[p.pos] {
+		case '\\':
+			p.pos += 2
+		case '"':
+			p.pos++
+			return nil
+		default:
+			p.pos++
+		}
+	}
+
+	return errors.New("unexpected end of JSON string")
+}
+
+func (p *jsonParser) expect(c byte) error {
+	if p.pos >= len(p.text) || p.text[p.pos] != c {
+		return fmt.Errorf("expected %q at %d", c, p.pos)
+	}
+
+	p.pos++
+	return nil
+}
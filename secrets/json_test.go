@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const jsonConfig = `{
+    "name": "app",
+    "eh": {
+        "encrypted": false,
+        "key": "",
+        "service": {"type": "local"},
+        "protect": ["password", "port", "tls", "keys"]
+    },
+    "db": {
+        "user": "admin",
+        "password": "p<a>ss\"word",
+        "port": 5432
+    },
+    "tls": {
+        "cert": "CERT",
+        "key": "KEY"
+    },
+    "keys": ["key-one", "key-two"]
+}
+`
+
+func TestJSONRoundTrip(t *testing.T) {
+	encrypted, err := Encrypt([]byte(jsonConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	if !json.Valid(encrypted) {
+		t.Fatalf("expected valid JSON, got:\n%s", encrypted)
+	}
+
+	for _, plaintext := range []string{"p<a>ss", "5432", `"CERT"`, "key-one", "key-two"} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	if !strings.HasPrefix(string(encrypted), "{\n    \"name\": \"app\",\n    \"eh\": {\n        \"encrypted\": true,") {
+		t.Errorf("expected order and indentation to be kept, got:\n%s", encrypted)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if string(decrypted) != jsonConfig {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
+
+func TestReadJSONWithInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	main := strings.Replace(jsonConfig, `"protect": ["password", "port", "tls", "keys"]`, `"protect": ["password"], "include": ["./shared.json"]`, 1)
+	shared := `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}, "protect": ["token"]}, "shared": {"token": "shared-token"}}`
+
+	encrypted, err := Encrypt([]byte(shared))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.json"), []byte(main), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "shared.json"), encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := Read(filepath.Join(dir, "main.json"))
+	if err != nil {
+		t.Fatal("failed to Read:", err)
+	}
+
+	var cfg struct {
+		Name string
+		DB   struct{ Password string }
+		Eh   interface{}
+		Keys []string
+
+		Shared struct{ Token string }
+	}
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		t.Fatalf("expected valid JSON, got %v:\n%s", err, contents)
+	}
+
+	if cfg.Name != "app" || cfg.DB.Password != `p<a>ss"word` || len(cfg.Keys) != 2 || cfg.Shared.Token != "shared-token" {
+		t.Errorf("unexpected contents:\n%s", contents)
+	}
+
+	if cfg.Eh != nil {
+		t.Errorf("expected the header to be removed, got:\n%s", contents)
+	}
+}
@@ -1,14 +1,19 @@
 package secrets
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
 )
 
 // EncryptionKey contians server key information
@@ -19,12 +24,74 @@ type EncryptionKey struct {
 	RawKey []byte `json:"-"`
 }
 
+// Destroy overwrites the raw key in memory. The key cannot be used to encrypt or decrypt afterwards.
+func (key *EncryptionKey) Destroy() {
+	wipe(key.RawKey)
+	key.RawKey = nil
+}
+
 // KeyService defines key methods
 type KeyService interface {
 	GenerateKey(kid string) (*EncryptionKey, error)
 	DecryptKey(key *EncryptionKey) error
 }
 
+// ContextKeyService is a KeyService with methods that can be cancelled or timed out using a context.
+type ContextKeyService interface {
+	KeyService
+	GenerateKeyContext(ctx context.Context, kid string) (*EncryptionKey, error)
+	DecryptKeyContext(ctx context.Context, key *EncryptionKey) error
+}
+
+// generateKey uses the context aware method if the service supports it. Otherwise, it stops waiting for the service when ctx is done.
+func generateKey(ctx context.Context, service KeyService, kid string) (*EncryptionKey, error) {
+	if s, ok := service.(ContextKeyService); ok {
+		return s.GenerateKeyContext(ctx, kid)
+	}
+
+	type result struct {
+		key *EncryptionKey
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		key, err := service.GenerateKey(kid)
+		done <- result{key, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.key, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// decryptKey uses the context aware method if the service supports it. Otherwise, it stops waiting for the service when ctx is done.
+func decryptKey(ctx context.Context, service KeyService, key *EncryptionKey) error {
+	if s, ok := service.(ContextKeyService); ok {
+		return s.DecryptKeyContext(ctx, key)
+	}
+
+	// decrypt a copy so that a late result does not race with the caller
+	tmp := *key
+	done := make(chan error, 1)
+	go func() {
+		done <- service.DecryptKey(&tmp)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			*key = tmp
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Ciphertext contains encrypted message
 type ciphertext struct {
 	KID  string `json:"kid"`
@@ -38,12 +105,26 @@ const (
 	// A256GCM identifies the encryption algorithm
 	A256GCM = "A256GCM"
 
+	// C20P identifies the ChaCha20-Poly1305 encryption algorithm
+	C20P = "C20P"
+
+	// XC20P identifies the XChaCha20-Poly1305 encryption algorithm. Its 24-byte random nonces can be used with the same key practically forever.
+	XC20P = "XC20P"
+
+	// A256SIV identifies the deterministic AES-SIV (RFC 5297) encryption algorithm. The same plaintext at the same path always produces the same ciphertext.
+	A256SIV = "A256SIV"
+
 	// B5JWKJSON identifies content type
 	B5JWKJSON = "b5+jwk+json"
 )
 
 // Decrypt decrypts a given ciphertext byte array using the web crypto key
 func (key *EncryptionKey) Decrypt(message []byte) ([]byte, error) {
+	return key.decrypt(message, nil)
+}
+
+// decrypt decrypts a message that was encrypted with the given additional data.
+func (key *EncryptionKey) decrypt(message []byte, additionalData []byte) ([]byte, error) {
 	m := &ciphertext{}
 	if err := json.Unmarshal(message, &m); err != nil {
 		var errorMsg string
@@ -60,10 +141,6 @@ func (key *EncryptionKey) Decrypt(message []byte) ([]byte, error) {
 		return nil, fmt.Errorf("attempt to decrypt message with KID %v using different KID %v", m.KID, key.KID)
 	}
 
-	if m.Enc != A256GCM {
-		return nil, fmt.Errorf("attempt to decrypt message with unknown enc: %+q", m.Enc)
-	}
-
 	if m.Cty != B5JWKJSON {
 		return nil, fmt.Errorf("attempt to decrypt message with unknown cty: %+q", m.Cty)
 	}
@@ -73,9 +150,9 @@ func (key *EncryptionKey) Decrypt(message []byte) ([]byte, error) {
 		return nil, errors.Wrapf(err, "invalid ciphertext in the message")
 	}
 
-	block, err := aes.NewCipher(key.RawKey)
+	aead, err := newAEAD(m.Enc, key.RawKey)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to create NewCipher")
+		return nil, errors.Wrap(err, "attempt to decrypt message")
 	}
 
 	iv, err := base64.RawURLEncoding.DecodeString(m.Iv)
@@ -83,29 +160,29 @@ func (key *EncryptionKey) Decrypt(message []byte) ([]byte, error) {
 		return nil, errors.Wrapf(err, "invalid iv in the message")
 	}
 
-	if len(iv) != 12 {
-		return nil, fmt.Errorf("invalid iv length (%d) in the message, expected 12", len(iv))
-	}
-
-	aead, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create NewGCM")
+	if len(iv) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid iv length (%d) in the message, expected %d", len(iv), aead.NonceSize())
 	}
 
-	plaintext, err := aead.Open(nil, iv, ciphertext, nil)
+	plaintext, err := aead.Open(nil, iv, ciphertext, bindingData(m.Enc, additionalData))
 	return plaintext, errors.Wrap(err, "failed to Open")
 }
 
 // Encrypt encrypts a given plaintext byte array
 func (key *EncryptionKey) Encrypt(plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key.RawKey)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create NewCipher")
+	return key.encrypt(plaintext, nil)
+}
+
+// encrypt encrypts a plaintext and binds it to the additional data, usually the path of the value. Only deterministic ciphers use additional data, so that ciphertexts created before it was introduced remain valid.
+func (key *EncryptionKey) encrypt(plaintext []byte, additionalData []byte) ([]byte, error) {
+	enc := key.Enc
+	if enc == "" {
+		enc = A256GCM
 	}
 
-	aead, err := cipher.NewGCM(block)
+	aead, err := newAEAD(enc, key.RawKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create NewGCM")
+		return nil, err
 	}
 
 	iv := make([]byte, aead.NonceSize())
@@ -113,10 +190,10 @@ func (key *EncryptionKey) Encrypt(plaintext []byte) ([]byte, error) {
 		return nil, errors.Wrap(err, "failed to get random iv")
 	}
 
-	data := aead.Seal(nil, iv, plaintext, nil)
+	data := aead.Seal(nil, iv, plaintext, bindingData(enc, additionalData))
 	m := &ciphertext{
 		KID:  key.KID,
-		Enc:  A256GCM,
+		Enc:  enc,
 		Cty:  B5JWKJSON,
 		Iv:   base64.RawURLEncoding.EncodeToString(iv),
 		Data: base64.RawURLEncoding.EncodeToString(data),
@@ -125,3 +202,43 @@ func (key *EncryptionKey) Encrypt(plaintext []byte) ([]byte, error) {
 	result, err := json.Marshal(m)
 	return result, errors.Wrap(err, "failed to Marshal")
 }
+
+// bindingData returns the additional data that is authenticated by the enc cipher.
+func bindingData(enc string, additionalData []byte) []byte {
+	if enc != A256SIV {
+		return nil
+	}
+
+	return additionalData
+}
+
+// newAEAD creates the cipher identified by enc.
+func newAEAD(enc string, rawKey []byte) (cipher.AEAD, error) {
+	switch enc {
+	case A256GCM:
+		block, err := aes.NewCipher(rawKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create NewCipher")
+		}
+
+		aead, err := cipher.NewGCM(block)
+		return aead, errors.Wrap(err, "failed to create NewGCM")
+	case C20P:
+		aead, err := chacha20poly1305.New(rawKey)
+		return aead, errors.Wrap(err, "failed to create ChaCha20-Poly1305")
+	case XC20P:
+		aead, err := chacha20poly1305.NewX(rawKey)
+		return aead, errors.Wrap(err, "failed to create XChaCha20-Poly1305")
+	case A256SIV:
+		sivKey := make([]byte, 64)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, rawKey, nil, []byte(A256SIV)), sivKey); err != nil {
+			return nil, errors.Wrap(err, "failed to derive AES-SIV key")
+		}
+
+		aead, err := newSIV(sivKey)
+		wipe(sivKey)
+		return aead, errors.Wrap(err, "failed to create AES-SIV")
+	default:
+		return nil, fmt.Errorf("unknown enc: %+q", enc)
+	}
+}
@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type blockingKeyService struct {
+	release chan struct{}
+}
+
+func (s *blockingKeyService) GenerateKey(kid string) (*EncryptionKey, error) {
+	<-s.release
+	return &EncryptionKey{KID: kid}, nil
+}
+
+func (s *blockingKeyService) DecryptKey(key *EncryptionKey) error {
+	<-s.release
+	return nil
+}
+
+func TestGenerateKeyStopsWaitingWhenContextIsDone(t *testing.T) {
+	svc := &blockingKeyService{release: make(chan struct{})}
+	defer close(svc.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := generateKey(ctx, svc, "key1"); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if err := decryptKey(ctx, svc, &EncryptionKey{KID: "key1"}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestDecryptContextFailsWhenContextIsCancelled(t *testing.T) {
+	encrypted, err := Encrypt([]byte(`
+eh {
+	encrypted = false
+	key = ""
+	service {
+		type = "local"
+	}
+	protect = ["password"]
+}
+
+password = "secret"
+`))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := DecryptContext(ctx, encrypted); err == nil {
+		t.Error("expected DecryptContext to fail with cancelled context")
+	}
+}
+
+func TestReadContextCancelsHTTPFetch(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := ReadContext(ctx, server.URL+"/config.hcl"); err == nil {
+		t.Error("expected ReadContext to fail with cancelled context")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("expected the request to be cancelled")
+	}
+}
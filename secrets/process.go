@@ -4,11 +4,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"reflect"
-	"strconv"
+	"strings"
 
-	"github.com/hashicorp/hcl/hcl/ast"
-	"github.com/hashicorp/hcl/hcl/token"
 	"github.com/pkg/errors"
 )
 
@@ -19,223 +16,239 @@ const (
 	opDecrypt operation = 2
 )
 
-func processNode(name string, node ast.Node, op operation, key *EncryptionKey, protect map[string]bool) error {
-	switch t := node.(type) {
-	case *ast.File:
-		if err := processNode(name, t.Node, op, key, protect); err != nil {
-			return errors.Wrapf(err, "failed processNode %q", name)
-		}
-	case *ast.ListType:
-		for _, node := range t.List {
-			if err := processNode(name, node, op, key, protect); err != nil {
-				return errors.Wrapf(err, "failed to processNode %q", name)
-			}
-		}
-	case *ast.ObjectType:
-		if err := processList(t.List, op, key, protect); err != nil {
-			return errors.Wrapf(err, "failed to processList %q", name)
-		}
+// processor encrypts or decrypts the protected values of a document. It does not depend on the format of the document.
+type processor struct {
+	op      operation
+	key     *EncryptionKey
+	protect map[string]bool
 
-	case *ast.ObjectList:
-		if err := processList(t, op, key, protect); err != nil {
-			return errors.Wrapf(err, "failed to processList %q", name)
-		}
-	case *ast.ObjectItem:
-		if err := processItem(t, op, key, protect); err != nil {
-			return errors.Wrapf(err, "failed to processItem %q", name)
-		}
-	case *ast.LiteralType:
-		if t.Token.Type == token.HEREDOC && protect[name] {
-			switch op {
-			case opEncrypt:
-				ciphertext, err := key.Encrypt([]byte(t.Token.Text))
-				if err != nil {
-					return errors.Wrapf(err, "failed to Encrypt %q", name)
-				}
+	// counts is the number of values encrypted for every protected name
+	counts map[string]int
 
-				encoded := base64.RawURLEncoding.EncodeToString(ciphertext)
-				t.Token.Text = "<<HEREDOC\n" + encoded + "\nHEREDOC"
-			case opDecrypt:
-				value := t.Token.Value().(string)
-				decoded, err := base64.RawURLEncoding.DecodeString(value)
-				if err != nil {
-					return errors.Wrapf(err, "failed to decode base64 value %q", value)
-				}
+	// unsupported lists the protected values that cannot be encrypted
+	unsupported []string
 
-				plaintext, err := key.Decrypt(decoded)
-				if err != nil {
-					return errors.Wrapf(err, "failed to decrypt value %q", value)
-				}
+	// compact selects the compact envelope for newly encrypted values
+	compact bool
 
-				t.Token.Text = string(plaintext)
-			}
+	// ciphertexts maps a value path and plaintext to its encoded ciphertext. It is filled in while decrypting a previous version of the file and consulted while encrypting, so that unchanged values keep their ciphertext.
+	ciphertexts map[string]map[string]string
+}
+
+func newProcessor(op operation, key *EncryptionKey, protect []string) *processor {
+	p := &processor{
+		op:      op,
+		key:     key,
+		protect: make(map[string]bool),
+		counts:  make(map[string]int),
+	}
+
+	for _, s := range protect {
+		p.protect[s] = true
+		p.counts[s] = 0
+	}
+
+	return p
+}
+
+// process encrypts or decrypts the protected values of the document. A value is protected if one of its names is in the protect list, the closest one is counted, or if it is annotated.
+func (p *processor) process(doc Document) error {
+	return doc.Walk(func(v Value) error {
+		name := p.protectedName(v.Names())
+		if a, ok := v.(AnnotatedValue); ok && name == "" && a.Annotated() {
+			// annotated values are counted by their own name
+			names := v.Names()
+			name = names[len(names)-1]
+		}
+
+		if name == "" {
+			return nil
 		}
 
-		if t.Token.Type == token.STRING && protect[name] {
-			value, err := strconv.Unquote(t.Token.Text)
-			if err != nil {
-				return errors.Wrapf(err, "failed to Unquote %q", name)
+		text, valueType, err := v.Get()
+		if unsupported, ok := errors.Cause(err).(*UnsupportedError); ok {
+			if p.op == opEncrypt {
+				p.unsupported = append(p.unsupported, fmt.Sprintf("%s (%s)", v.Path(), unsupported.Reason))
+				return SkipValue
 			}
 
-			switch op {
-			case opEncrypt:
-				ciphertext, err := key.Encrypt([]byte(value))
-				if err != nil {
-					return errors.Wrapf(err, "failed to Encrypt %q", name)
-				}
+			// it was not encrypted, but the values inside may be
+			return nil
+		}
 
-				encoded := base64.RawURLEncoding.EncodeToString(ciphertext)
-				t.Token.Text = strconv.Quote(encoded)
-			case opDecrypt:
-				decoded, err := base64.RawURLEncoding.DecodeString(value)
-				if err != nil {
-					return errors.Wrapf(err, "failed to decode base64 value %q", value)
-				}
+		if err != nil {
+			return err
+		}
 
-				plaintext, err := key.Decrypt(decoded)
-				if err != nil {
-					return errors.Wrapf(err, "failed to decrypt value %q", value)
+		switch p.op {
+		case opEncrypt:
+			if r, ok := v.(RawValue); ok && valueType == ValueString {
+				if raw, rawType, ok := r.Raw(); ok {
+					// the text is encrypted, so that decrypting restores it exactly
+					text, valueType = raw, rawType
 				}
-
-				t.Token.Text = strconv.Quote(string(plaintext))
-			default:
-				return fmt.Errorf("failed because of unknown operation %d", op)
 			}
+			return p.encryptValue(name, v, text, valueType)
+		case opDecrypt:
+			names := v.Names()
+			if name != names[len(names)-1] && !isCiphertext(text) {
+				// older versions only encrypted the values whose own name was protected, so the values inside a protected block can be plaintext
+				return nil
+			}
+			return p.decryptValue(v, text, valueType)
+		default:
+			return fmt.Errorf("failed because of unknown operation %d", p.op)
 		}
-	default:
-		return fmt.Errorf("failed because of unknown node type %v", reflect.TypeOf(t))
-	}
-
-	return nil
+	})
 }
 
-func processList(list *ast.ObjectList, op operation, key *EncryptionKey, protect map[string]bool) error {
-	for _, item := range list.Items {
-		if err := processItem(item, op, key, protect); err != nil {
-			return errors.Wrap(err, "failed to processItem")
+// protectedName returns the last of the names that is protected.
+func (p *processor) protectedName(names []string) string {
+	for i := len(names) - 1; i >= 0; i-- {
+		if p.protect[names[i]] {
+			return names[i]
 		}
 	}
 
-	return nil
+	return ""
 }
 
-func processItem(item *ast.ObjectItem, op operation, key *EncryptionKey, protect map[string]bool) error {
-	name := item.Keys[0].Token.Text
+// encryptValue replaces the value with its encrypted string. Values of other types than strings are prefixed with their type, and the values inside them are encrypted with them.
+func (p *processor) encryptValue(name string, v Value, text string, valueType string) error {
+	encoded, err := p.seal(name, v.Path(), text)
+	if err != nil {
+		return errors.Wrapf(err, "failed to Encrypt %q", v.Path())
+	}
 
-	if len(item.Keys) == 1 && name == "eh" {
-		// do not process eh element
-		return nil
+	if valueType != ValueString {
+		encoded = typePrefix(valueType) + encoded
 	}
 
-	if err := processNode(name, item.Val, op, key, protect); err != nil {
-		return errors.Wrapf(err, "failed to processNode %q", name)
+	if err := v.Set(encoded, ValueString); err != nil {
+		return err
 	}
-	return nil
+
+	return SkipValue
 }
 
-func addEncryptionKey(node ast.Node, key *EncryptionKey) error {
-	keyEntry, err := getHeaderValue(node, "key")
-	if err != nil {
-		return errors.Wrap(err, "failed to getHeaderValue for 'key'")
+// decryptValue restores the value and its type from the encrypted string.
+func (p *processor) decryptValue(v Value, text string, valueType string) error {
+	if valueType != ValueString {
+		// only strings can be encrypted values, the values inside are walked
+		return nil
 	}
 
-	marshaledKey, err := json.Marshal(key)
+	// heredoc bodies end with a newline, encoded values never contain spaces
+	valueType, encoded := splitValueType(strings.TrimSpace(text))
+	plaintext, err := p.open(v.Path(), encoded)
 	if err != nil {
-		return errors.Wrap(err, "failed to Marshal key")
+		return err
 	}
 
-	encodedKey := base64.RawURLEncoding.EncodeToString(marshaledKey)
-	keyEntry.Token.Text = strconv.Quote(encodedKey)
-
-	encryptedEntry, err := getHeaderValue(node, "encrypted")
-	if err != nil {
-		return errors.Wrap(err, "failed to getHeaderValue for 'encrypted'")
+	if err := v.Set(plaintext, valueType); err != nil {
+		return err
 	}
 
-	encryptedEntry.Token.Text = "true"
-
-	return nil
+	// the restored value is not encrypted
+	return SkipValue
 }
 
-func removeEncryptionKey(node ast.Node) error {
-	keyEntry, err := getHeaderValue(node, "key")
-	if err != nil {
-		return errors.Wrap(err, "failed to getHeaderValue for 'key'")
+// seal returns the encoded ciphertext of the value at path. The ciphertext from the previous version of the file is reused if the value has not changed.
+func (p *processor) seal(name string, path string, plaintext string) (string, error) {
+	p.counts[name]++
+
+	if encoded, ok := p.ciphertexts[path][plaintext]; ok {
+		return encoded, nil
 	}
 
-	keyEntry.Token.Text = strconv.Quote("")
+	b := []byte(plaintext)
+	defer wipe(b)
 
-	encryptedEntry, err := getHeaderValue(node, "encrypted")
-	if err != nil {
-		return errors.Wrap(err, "failed to getHeaderValue for 'encrypted'")
+	if p.compact {
+		return p.key.sealCompact(b, []byte(path))
 	}
 
-	encryptedEntry.Token.Text = "false"
+	ciphertext, err := p.key.encrypt(b, []byte(path))
+	if err != nil {
+		return "", err
+	}
 
-	return nil
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
 }
 
-func getHeaderValue(node ast.Node, name string) (*ast.LiteralType, error) {
-	var list *ast.ObjectList
+// open decrypts the encoded ciphertext of the value at path and remembers it if ciphertexts are being collected. The plaintext is returned as a string for the document, so only the decrypted slice is wiped.
+func (p *processor) open(path string, encoded string) (string, error) {
+	var plaintext []byte
+	defer func() { wipe(plaintext) }()
 
-	file, ok := node.(*ast.File)
-	if ok {
-		list, ok = file.Node.(*ast.ObjectList)
+	if isCompact(encoded) {
+		var err error
+		plaintext, err = p.key.openCompact(encoded, []byte(path))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to decrypt value %q", encoded)
+		}
 	} else {
-		list, ok = node.(*ast.ObjectList)
-	}
+		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to decode base64 value %q", encoded)
+		}
 
-	if !ok {
-		return nil, errors.New("failed, unexpected .hcl format")
+		plaintext, err = p.key.decrypt(decoded, []byte(path))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to decrypt value %q", encoded)
+		}
 	}
 
-	eh := list.Filter("eh")
-	if len(eh.Items) != 1 {
-		return nil, errors.New("failed, must have 'eh' element")
+	if p.ciphertexts != nil {
+		if p.ciphertexts[path] == nil {
+			p.ciphertexts[path] = make(map[string]string)
+		}
+		p.ciphertexts[path][string(plaintext)] = encoded
 	}
 
-	obj, ok := eh.Items[0].Val.(*ast.ObjectType)
-	if !ok {
-		return nil, errors.New("failed, invalid 'eh' element")
-	}
+	return string(plaintext), nil
+}
 
-	keyEntry := obj.List.Filter(name)
-	if len(keyEntry.Items) == 0 {
-		return nil, fmt.Errorf("failed, no %q element found in 'eh'", name)
-	}
+// typePrefix is stored before the encrypted value of a type other than string.
+func typePrefix(valueType string) string {
+	return "eh:" + valueType + ":"
+}
 
-	if len(keyEntry.Items) > 1 {
-		return nil, fmt.Errorf("failed, multiple %q elements found in 'eh'", name)
+// splitValueType returns the original type of the encrypted value and the encoded ciphertext. The type is empty for strings. Compact ciphertexts also start with "eh:", their version is not a type.
+func splitValueType(value string) (string, string) {
+	if !strings.HasPrefix(value, "eh:") || isCompact(value) {
+		return ValueString, value
 	}
 
-	val, ok := keyEntry.Items[0].Val.(*ast.LiteralType)
-	if !ok {
-		return nil, fmt.Errorf("failed, invalid %q element in 'eh'", name)
+	i := strings.IndexByte(value[3:], ':')
+	if i < 0 {
+		return ValueString, value
 	}
 
-	return val, nil
+	return value[3 : 3+i], value[4+i:]
 }
 
-func removeHeader(tree ast.Node) error {
-	var list *ast.ObjectList
-
-	file, ok := tree.(*ast.File)
-	if ok {
-		list, ok = file.Node.(*ast.ObjectList)
-	} else {
-		list, ok = tree.(*ast.ObjectList)
+// setEncryptionKey stores the wrapped key in the header and marks the document as encrypted.
+func setEncryptionKey(doc Document, key *EncryptionKey) error {
+	marshaledKey, err := json.Marshal(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to Marshal key")
 	}
 
-	if !ok {
-		return errors.New("failed, unexpected .hcl format")
+	if err := doc.SetHeaderValue("key", base64.RawURLEncoding.EncodeToString(marshaledKey)); err != nil {
+		return err
 	}
 
-	for i, item := range list.Items {
-		if item.Keys[0].Token.Text == "eh" {
-			list.Items = append(list.Items[:i], list.Items[i+1:]...)
-			return nil
+	return doc.SetHeaderValue("encrypted", true)
+}
+
+// removeEncryptionKey marks the document as not encrypted. The wrapped key is kept in the header if keepKey is set, so that it can be reused by the next Encrypt.
+func removeEncryptionKey(doc Document, keepKey bool) error {
+	if !keepKey {
+		if err := doc.SetHeaderValue("key", ""); err != nil {
+			return err
 		}
 	}
 
-	return nil
+	return doc.SetHeaderValue("encrypted", false)
 }
@@ -0,0 +1,241 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// redacted is printed instead of the value of a Secret.
+const redacted = "[REDACTED]"
+
+// Secret is a decrypted value that is not printed, logged or marshaled by accident. It prints as [REDACTED] with every verb of the fmt package, including %+v and %#v, and is marshaled as "[REDACTED]" to JSON and text. The value is only returned by Reveal, and is overwritten by Destroy.
+//
+// Secret fields can be decoded with Decode from all built-in formats, and from JSON, YAML and TOML with their own decoders. Fields of type Secret are secret without an `eh:"secret"` tag.
+type Secret struct {
+	b []byte
+}
+
+// NewSecret returns a Secret with a copy of the value.
+func NewSecret(value string) Secret {
+	return Secret{b: []byte(value)}
+}
+
+// Reveal returns the value.
+func (s Secret) Reveal() string {
+	return string(s.b)
+}
+
+// Destroy overwrites the value with zeros. Strings returned by Reveal, and the strings the value was decoded from, are not changed.
+func (s *Secret) Destroy() {
+	wipe(s.b)
+	s.b = nil
+}
+
+// Format prints [REDACTED] for every verb.
+func (s Secret) Format(f fmt.State, verb rune) {
+	f.Write([]byte(redacted))
+}
+
+// String returns [REDACTED].
+func (s Secret) String() string {
+	return redacted
+}
+
+// MarshalJSON returns "[REDACTED]".
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+// MarshalText returns [REDACTED].
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(redacted), nil
+}
+
+// UnmarshalText sets the value to a copy of the text. JSON strings, YAML scalars and TOML strings are decoded with it.
+func (s *Secret) UnmarshalText(text []byte) error {
+	s.b = append([]byte(nil), text...)
+	return nil
+}
+
+var secretType = reflect.TypeOf(Secret{})
+
+// decodeSecrets calls decode with out, or if out has Secret fields and the decoder does not know them, with a value of a type that has strings instead, whose values are then copied to out.
+func decodeSecrets(out interface{}, decode func(interface{}) error) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return decode(out)
+	}
+
+	shadow, err := shadowType(v.Type().Elem(), map[reflect.Type]bool{})
+	if err != nil {
+		return err
+	}
+
+	if shadow == v.Type().Elem() {
+		return decode(out)
+	}
+
+	target := reflect.New(shadow)
+	copyShadow(target.Elem(), v.Elem())
+	if err := decode(target.Interface()); err != nil {
+		return err
+	}
+
+	copyShadow(v.Elem(), target.Elem())
+	return nil
+}
+
+// shadowType returns the type with string in place of Secret, or the type itself if it has no Secret. Unexported fields are left out, because decoders skip them.
+func shadowType(t reflect.Type, visiting map[reflect.Type]bool) (reflect.Type, error) {
+	if t == secretType {
+		return reflect.TypeOf(""), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		elem, err := shadowType(t.Elem(), visiting)
+		if err != nil || elem == t.Elem() {
+			return t, err
+		}
+
+		switch t.Kind() {
+		case reflect.Ptr:
+			return reflect.PtrTo(elem), nil
+		case reflect.Slice:
+			return reflect.SliceOf(elem), nil
+		case reflect.Array:
+			return reflect.ArrayOf(t.Len(), elem), nil
+		default:
+			return reflect.MapOf(t.Key(), elem), nil
+		}
+
+	case reflect.Struct:
+		if visiting[t] {
+			if hasSecret(t, map[reflect.Type]bool{}) {
+				return nil, fmt.Errorf("failed, recursive type %s with secrets cannot be decoded", t)
+			}
+			return t, nil
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		changed := false
+		var fields []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				changed = true
+				continue
+			}
+
+			fieldType, err := shadowType(field.Type, visiting)
+			if err != nil {
+				return nil, err
+			}
+
+			changed = changed || fieldType != field.Type
+			field.Type = fieldType
+			fields = append(fields, field)
+		}
+
+		if !changed || !hasSecret(t, map[reflect.Type]bool{}) {
+			return t, nil
+		}
+
+		return reflect.StructOf(fields), nil
+	}
+
+	return t, nil
+}
+
+// hasSecret tells if the type has a Secret in it.
+func hasSecret(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if t == secretType {
+		return true
+	}
+
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasSecret(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" && hasSecret(t.Field(i).Type, visited) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// copyShadow copies src to dst, where one of them has strings in place of the Secret values of the other.
+func copyShadow(dst, src reflect.Value) {
+	if dst.Type() == src.Type() {
+		dst.Set(src)
+		return
+	}
+
+	switch {
+	case dst.Type() == secretType:
+		dst.Set(reflect.ValueOf(NewSecret(src.String())))
+		return
+	case src.Type() == secretType:
+		dst.SetString(src.Interface().(Secret).Reveal())
+		return
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		dst.Set(reflect.New(dst.Type().Elem()))
+		copyShadow(dst.Elem(), src.Elem())
+
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			copyShadow(dst.Index(i), src.Index(i))
+		}
+
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyShadow(dst.Index(i), src.Index(i))
+		}
+
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return
+		}
+		dst.Set(reflect.MakeMapWithSize(dst.Type(), src.Len()))
+		for _, key := range src.MapKeys() {
+			value := reflect.New(dst.Type().Elem()).Elem()
+			copyShadow(value, src.MapIndex(key))
+			dst.SetMapIndex(key, value)
+		}
+
+	case reflect.Struct:
+		// fields are matched by name, because the shadow type has no unexported fields
+		for i := 0; i < dst.NumField(); i++ {
+			if dst.Type().Field(i).PkgPath != "" {
+				continue
+			}
+
+			if field := src.FieldByName(dst.Type().Field(i).Name); field.IsValid() {
+				copyShadow(dst.Field(i), field)
+			}
+		}
+	}
+}
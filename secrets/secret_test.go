@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSecretIsRedacted(t *testing.T) {
+	s := NewSecret("hunter2")
+	cfg := struct {
+		Password Secret `json:"password"`
+		Ptr      *Secret
+	}{s, &s}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%d"} {
+		if text := fmt.Sprintf(format, s); text != redacted {
+			t.Errorf("expected %s to print %s, got %s", format, redacted, text)
+		}
+
+		if text := fmt.Sprintf(format, cfg); strings.Contains(text, "hunter2") {
+			t.Errorf("expected %s of a struct not to print the secret, got %s", format, text)
+		}
+	}
+
+	marshaled, err := json.Marshal(cfg)
+	if err != nil || string(marshaled) != `{"password":"[REDACTED]","Ptr":"[REDACTED]"}` {
+		t.Errorf("unexpected JSON %s (%v)", marshaled, err)
+	}
+
+	text, err := s.MarshalText()
+	if err != nil || string(text) != redacted {
+		t.Errorf("unexpected text %s (%v)", text, err)
+	}
+
+	if s.Reveal() != "hunter2" {
+		t.Errorf("expected Reveal to return the value, got %q", s.Reveal())
+	}
+}
+
+func TestSecretDestroy(t *testing.T) {
+	var s Secret
+	if err := json.Unmarshal([]byte(`"hunter2"`), &s); err != nil {
+		t.Fatalf("failed to Unmarshal: %v", err)
+	}
+
+	b := s.b
+	s.Destroy()
+	if s.Reveal() != "" || string(b) != strings.Repeat("\x00", len("hunter2")) {
+		t.Errorf("expected value to be zeroed, got %q", b)
+	}
+}
+
+func TestDecodeSecret(t *testing.T) {
+	type db struct {
+		Host     string `hcl:"host" json:"host" yaml:"host" toml:"host"`
+		Password Secret `hcl:"password" json:"password" yaml:"password" toml:"password"`
+	}
+
+	type config struct {
+		DB    db                `hcl:"db" json:"db" yaml:"db" toml:"db"`
+		Keys  map[string]Secret `hcl:"keys" json:"keys" yaml:"keys" toml:"keys"`
+		count int
+	}
+
+	type hcl2Config struct {
+		DB struct {
+			Host     string `hcl:"host,attr"`
+			Password Secret `hcl:"password,attr"`
+		} `hcl:"db,block"`
+	}
+
+	type dotenvConfig struct {
+		Host     string `json:"DB_HOST"`
+		Password Secret `json:"DB_PASSWORD"`
+	}
+
+	dir := writeFiles(t, map[string]string{
+		"config.hcl":  "eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n}\n\ndb {\n\thost = \"localhost\"\n\tpassword = \"secret\"\n}\n\nkeys {\n\tapi = \"api-secret\"\n}\n",
+		"config.tf":   "eh {\n  encrypted = false\n  key = \"\"\n  syntax = \"hcl2\"\n  service {\n    type = \"local\"\n  }\n}\n\ndb {\n  host = \"localhost\"\n  password = \"secret\"\n}\n",
+		"config.json": `{"eh": {"encrypted": false, "key": "", "service": {"type": "local"}}, "db": {"host": "localhost", "password": "secret"}, "keys": {"api": "api-secret"}}`,
+		"config.yaml": "eh:\n  encrypted: false\n  key: \"\"\n  service:\n    type: local\ndb:\n  host: localhost\n  password: secret\nkeys:\n  api: api-secret\n",
+		"config.toml": "[eh]\nencrypted = false\nkey = \"\"\n[eh.service]\ntype = \"local\"\n\n[db]\nhost = \"localhost\"\npassword = \"secret\"\n\n[keys]\napi = \"api-secret\"\n",
+		"config.env":  "#eh encrypted = false\n#eh key = \"\"\n#eh service { type = \"local\" }\nDB_HOST=localhost\nDB_PASSWORD=\"secret\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"config.hcl", "config.json", "config.yaml", "config.toml"} {
+		cfg := config{count: 1}
+		if err := Decode(filepath.Join(dir, name), &cfg); err != nil {
+			t.Errorf("failed to Decode %s: %v", name, err)
+			continue
+		}
+
+		if cfg.DB.Host != "localhost" || cfg.DB.Password.Reveal() != "secret" || cfg.Keys["api"].Reveal() != "api-secret" || cfg.count != 1 {
+			t.Errorf("unexpected values of %s: %+v %q %q", name, cfg, cfg.DB.Password.Reveal(), cfg.Keys["api"].Reveal())
+		}
+	}
+
+	var h hcl2Config
+	if err := Decode(filepath.Join(dir, "config.tf"), &h); err != nil {
+		t.Errorf("failed to Decode config.tf: %v", err)
+	} else if h.DB.Host != "localhost" || h.DB.Password.Reveal() != "secret" {
+		t.Errorf("unexpected values of config.tf: %+v", h.DB)
+	}
+
+	var e dotenvConfig
+	if err := Decode(filepath.Join(dir, "config.env"), &e); err != nil {
+		t.Errorf("failed to Decode config.env: %v", err)
+	} else if e.Host != "localhost" || e.Password.Reveal() != "secret" {
+		t.Errorf("unexpected values of config.env: %+v", e)
+	}
+
+	if !reflect.DeepEqual(ProtectList(&config{}), []string{"keys", "password"}) {
+		t.Errorf("expected Secret fields to be protected, got %v", ProtectList(&config{}))
+	}
+}
@@ -2,16 +2,19 @@ package secrets
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"path"
+	"net/http"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/agilebits/urlreader"
-	"github.com/hashicorp/hcl"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/hashicorp/hcl/hcl/printer"
 	"github.com/pkg/errors"
@@ -24,106 +27,268 @@ const (
 
 // Encrypt will generate a new key and encrypt the protected values.
 func Encrypt(contents []byte) ([]byte, error) {
-	tree, err := hcl.ParseBytes(contents)
+	return EncryptContext(context.Background(), contents)
+}
+
+// EncryptContext is like Encrypt but gives up waiting for the key service when ctx is done.
+func EncryptContext(ctx context.Context, contents []byte) ([]byte, error) {
+	result, _, err := EncryptWithReport(ctx, contents, EncryptOptions{})
+	return result, err
+}
+
+// Reencrypt encrypts the protected values like Encrypt, but reuses the key of the previous encrypted version of the same contents and keeps the previous ciphertext of every value that has not changed. This way a diff only shows the values that were actually modified.
+func Reencrypt(contents []byte, previous []byte) ([]byte, error) {
+	return ReencryptContext(context.Background(), contents, previous)
+}
+
+// ReencryptContext is like Reencrypt but gives up waiting for the key service when ctx is done.
+func ReencryptContext(ctx context.Context, contents []byte, previous []byte) ([]byte, error) {
+	result, _, err := EncryptWithReport(ctx, contents, EncryptOptions{Previous: previous})
+	return result, err
+}
+
+// EncryptOptions changes the way EncryptWithReport works.
+type EncryptOptions struct {
+	// Name is the file name or url of the contents. Its extension, such as ".json", selects the format, which is otherwise detected from the contents.
+	Name string
+
+	// Previous is the previous encrypted version of the contents. If set, its key and unchanged ciphertexts are reused as in Reencrypt.
+	Previous []byte
+
+	// Strict makes encryption fail if an entry in the protect list does not match any value, or if a protected value cannot be encrypted.
+	Strict bool
+}
+
+// Report describes the values that were protected.
+type Report struct {
+	// Protected is the number of encrypted values for every entry in the protect list.
+	Protected map[string]int
+
+	// Unmatched lists the entries in the protect list that did not match any value, most likely because of a typo.
+	Unmatched []string
+
+	// Unsupported lists the protected values that cannot be encrypted and are left in plaintext, as "path (reason)".
+	Unsupported []string
+}
+
+// EncryptWithReport encrypts the protected values like Encrypt and reports how many values were encrypted for every entry in the protect list, and the protected values that cannot be encrypted.
+func EncryptWithReport(ctx context.Context, contents []byte, options EncryptOptions) ([]byte, *Report, error) {
+	var previous *previousVersion
+	if options.Previous != nil {
+		var err error
+		previous, err = loadPrevious(ctx, options.Name, options.Previous)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer previous.key.Destroy()
+	}
+
+	result, p, err := encrypt(ctx, options.Name, contents, previous)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to Parse")
+		return nil, nil, err
 	}
 
-	var wrapper Wrapper
-	if err := hcl.DecodeObject(&wrapper, tree); err != nil {
-		return nil, errors.Wrap(err, "failed to DecodeObject")
+	report := &Report{Protected: p.counts, Unsupported: p.unsupported}
+	for name, count := range p.counts {
+		if count == 0 {
+			report.Unmatched = append(report.Unmatched, name)
+		}
 	}
+	sort.Strings(report.Unmatched)
 
-	if wrapper.Header.Encrypted {
-		return nil, errors.New("contents is already encrypted")
+	if options.Strict && len(report.Unsupported) > 0 {
+		return nil, report, fmt.Errorf("failed, cannot encrypt protected values: %s", strings.Join(report.Unsupported, ", "))
 	}
 
-	keyService, err := getKeyService(wrapper.Header.Service)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to obtain key service for parameters: %v", wrapper.Header.Service)
+	if options.Strict && len(report.Unmatched) > 0 {
+		return nil, report, fmt.Errorf("failed, protected keys not found: %s", strings.Join(report.Unmatched, ", "))
 	}
 
-	kid := "sm-" + time.Now().Format(time.RFC3339)
-	encryptionKey, err := keyService.GenerateKey(kid)
+	return result, report, nil
+}
+
+// loadPrevious decrypts the previous version of the contents and collects its ciphertexts.
+func loadPrevious(ctx context.Context, name string, previous []byte) (*previousVersion, error) {
+	_, doc, header, err := parseDocument(name, previous)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to generate encryption key")
+		return nil, errors.Wrap(err, "failed to parse previous contents")
 	}
 
-	protect := make(map[string]bool)
-	for _, s := range wrapper.Header.Protect {
-		protect[s] = true
+	if !header.Encrypted {
+		return nil, errors.New("previous contents is not encrypted")
 	}
 
-	if err := processNode("", tree, opEncrypt, encryptionKey, protect); err != nil {
-		return nil, errors.Wrap(err, "failed to process")
+	keyService, err := getKeyService(header.Service)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to obtain key service for parameters: %v", header.Service)
 	}
 
-	if err := addEncryptionKey(tree, encryptionKey); err != nil {
-		return nil, errors.Wrap(err, "failed to addEncryptionKey")
+	encryptionKey, err := unwrapKey(ctx, keyService, header.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt previous key")
 	}
 
-	var c printer.Config
-	var result bytes.Buffer
-	if err := c.Fprint(&result, tree); err != nil {
+	p := newProcessor(opDecrypt, encryptionKey, header.Protect)
+	p.ciphertexts = make(map[string]map[string]string)
+	if err := p.process(doc); err != nil {
+		encryptionKey.Destroy()
+		return nil, errors.Wrap(err, "failed to process previous contents")
+	}
+
+	enc, err := getCipher(header.Cipher)
+	if err != nil {
+		encryptionKey.Destroy()
 		return nil, err
 	}
 
-	return result.Bytes(), nil
+	return &previousVersion{
+		service:     header.Service,
+		enc:         enc,
+		compact:     header.Compact,
+		key:         encryptionKey,
+		ciphertexts: p.ciphertexts,
+	}, nil
+}
+
+// previousVersion holds the key and the ciphertexts of the previous encrypted version of the contents.
+type previousVersion struct {
+	service ServiceParams
+
+	// enc and compact are the cipher and envelope of the ciphertexts, which are only reused if the contents still asks for them
+	enc     string
+	compact bool
+
+	key         *EncryptionKey
+	ciphertexts map[string]map[string]string
 }
 
-// decryptWithHeader will access the key service and decrypt the protected values in the content. It returns unformatted AST file and 'eh' header found in the contents.
-func decryptWithHeader(contents []byte, failIfNotEncrypted bool) (*ast.File, *Header, error) {
-	tree, err := hcl.ParseBytes(contents)
+// encrypt encrypts the contents and returns the processor with the details of the protected values.
+func encrypt(ctx context.Context, name string, contents []byte, previous *previousVersion) ([]byte, *processor, error) {
+	_, doc, header, err := parseDocument(name, contents)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to ParseBytes")
+		return nil, nil, err
 	}
 
-	var wrapper Wrapper
-	if err := hcl.DecodeObject(&wrapper, tree); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to DecodeObject")
+	if header.Encrypted {
+		return nil, nil, errors.New("contents is already encrypted")
 	}
 
-	if !wrapper.Header.Encrypted {
-		if failIfNotEncrypted {
-			return nil, nil, errors.New("contents is not encrypted")
+	enc, err := getCipher(header.Cipher)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyService, err := getKeyService(header.Service)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to obtain key service for parameters: %v", header.Service)
+	}
+
+	var encryptionKey *EncryptionKey
+	switch {
+	case previous != nil:
+		if previous.service != header.Service {
+			return nil, nil, fmt.Errorf("previous contents uses different key service parameters: %v", previous.service)
+		}
+		encryptionKey = previous.key
+	case header.Key != "":
+		// the key was kept by Decrypt, reuse it so that deterministic ciphertexts stay the same
+		encryptionKey, err = unwrapKey(ctx, keyService, header.Key)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to reuse encryption key")
 		}
+	default:
+		kid := "sm-" + time.Now().Format(time.RFC3339)
+		encryptionKey, err = generateKey(ctx, keyService, kid)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to generate encryption key")
+		}
+	}
 
-		return tree, &wrapper.Header, nil
+	if previous == nil {
+		defer encryptionKey.Destroy()
 	}
 
-	keyBytes, err := base64.RawURLEncoding.DecodeString(wrapper.Header.Key)
+	encryptionKey.Enc = enc
+
+	p := newProcessor(opEncrypt, encryptionKey, header.Protect)
+	p.compact = header.Compact
+	if previous != nil && previous.enc == enc && previous.compact == header.Compact {
+		p.ciphertexts = previous.ciphertexts
+	}
+
+	if err := p.process(doc); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to process")
+	}
+
+	if err := setEncryptionKey(doc, encryptionKey); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to setEncryptionKey")
+	}
+
+	result, err := doc.Bytes()
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to decode the encryption key")
+		return nil, nil, err
 	}
 
-	encryptionKey := EncryptionKey{}
-	if err := json.Unmarshal(keyBytes, &encryptionKey); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to Unmarshal the encryption key")
+	return result, p, nil
+}
+
+// decryptWithHeader will access the key service and decrypt the protected values in the content. It returns the format, the unformatted document and 'eh' header found in the contents.
+func decryptWithHeader(ctx context.Context, name string, contents []byte, failIfNotEncrypted bool) (Format, Document, *Header, error) {
+	format, doc, header, err := parseDocument(name, contents)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	keyService, err := getKeyService(wrapper.Header.Service)
+	if !header.Encrypted {
+		if failIfNotEncrypted {
+			return nil, nil, nil, errors.New("contents is not encrypted")
+		}
+
+		return format, doc, header, nil
+	}
+
+	keyService, err := getKeyService(header.Service)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "failed to obtain key service for parameters: %v", header.Service)
+	}
+
+	encryptionKey, err := unwrapKey(ctx, keyService, header.Key)
 	if err != nil {
-		return nil, nil, errors.Wrapf(err, "failed to obtain key service for parameters: %v", wrapper.Header.Service)
+		return nil, nil, nil, err
 	}
+	defer encryptionKey.Destroy()
 
-	if err := keyService.DecryptKey(&encryptionKey); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to obtain decrypt key")
+	p := newProcessor(opDecrypt, encryptionKey, header.Protect)
+	if err := p.process(doc); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to process")
 	}
 
-	protect := make(map[string]bool)
-	for _, s := range wrapper.Header.Protect {
-		protect[s] = true
+	// deterministic ciphertexts are only stable if the next Encrypt uses the same key
+	keepKey := encryptionKey.Enc == A256SIV
+	if err := removeEncryptionKey(doc, keepKey); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to removeEncryptionKey")
 	}
 
-	if err := processNode("", tree, opDecrypt, &encryptionKey, protect); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to process")
+	return format, doc, header, nil
+}
+
+// unwrapKey decodes the encryption key stored in the header and decrypts it using the key service.
+func unwrapKey(ctx context.Context, keyService KeyService, encoded string) (*EncryptionKey, error) {
+	keyBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the encryption key")
 	}
 
-	if err := removeEncryptionKey(tree); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to removeEncryptionKey")
+	encryptionKey := &EncryptionKey{}
+	if err := json.Unmarshal(keyBytes, encryptionKey); err != nil {
+		return nil, errors.Wrap(err, "failed to Unmarshal the encryption key")
 	}
 
-	return tree, &wrapper.Header, nil
+	if err := decryptKey(ctx, keyService, encryptionKey); err != nil {
+		return nil, errors.Wrap(err, "failed to obtain decrypt key")
+	}
+
+	return encryptionKey, nil
 }
 
 // FormatASTFile returns formatted text representation of the file
@@ -134,66 +299,214 @@ func FormatASTFile(file *ast.File) ([]byte, error) {
 		return nil, err
 	}
 
+	// a heredoc at the end of the file is only terminated by a newline
+	if !bytes.HasSuffix(result.Bytes(), []byte("\n")) {
+		result.WriteByte('\n')
+	}
+
 	return result.Bytes(), nil
 }
 
 // Decrypt will access the key service and decrypt the protected values in the content.
 func Decrypt(contents []byte) ([]byte, error) {
-	result, _, err := decryptWithHeader(contents, true)
+	return DecryptContext(context.Background(), contents)
+}
+
+// DecryptContext is like Decrypt but gives up waiting for the key service when ctx is done.
+func DecryptContext(ctx context.Context, contents []byte) ([]byte, error) {
+	return DecryptWithOptions(ctx, contents, DecryptOptions{})
+}
+
+// DecryptOptions changes the way DecryptWithOptions works.
+type DecryptOptions struct {
+	// Name is the file name or url of the contents. Its extension selects the format, as in EncryptOptions.
+	Name string
+}
+
+// DecryptWithOptions decrypts the contents like DecryptContext.
+func DecryptWithOptions(ctx context.Context, contents []byte, options DecryptOptions) ([]byte, error) {
+	_, result, _, err := decryptWithHeader(ctx, options.Name, contents, true)
 	if err != nil {
 		return nil, err
 	}
 
-	return FormatASTFile(result)
+	return result.Bytes()
 }
 
 // Read loads and decrypt the contents at the specifed URL. It also processes and merges all included files specified in the header.
 func Read(url string) ([]byte, error) {
-	reader, err := urlreader.Open(url)
+	return ReadContext(context.Background(), url)
+}
+
+// ReadContext is like Read but gives up waiting for the url fetches and the key service when ctx is done.
+func ReadContext(ctx context.Context, url string) ([]byte, error) {
+	return ReadWithOptions(ctx, url, ReadOptions{})
+}
+
+// ReadOptions changes the way ReadWithOptions works.
+type ReadOptions struct {
+	// Env is the environment, such as "prod". The overlay of the environment next to the url, config.prod.hcl for config.hcl, is merged after the included files and overrides their values. Overlays are decrypted with their own header, so every environment can use its own key service. An overlay that does not exist is skipped.
+	Env string
+
+	// Interpolate replaces ${env:NAME} with the value of the environment variable and ${file:PATH} with the contents of the file, without a trailing newline, in the string values of every file after it is decrypted. $${ stands for ${, and other placeholders are kept.
+	Interpolate bool
+
+	// Strict makes interpolation fail if a variable is not set or a file cannot be read, instead of replacing them with nothing.
+	Strict bool
+}
+
+// ReadWithOptions reads the contents at the url like ReadContext, and also merges the overlay of an environment and interpolates values if the options say so.
+func ReadWithOptions(ctx context.Context, url string, options ReadOptions) ([]byte, error) {
+	result, _, err := ReadWithReport(ctx, url, options)
+	return result, err
+}
+
+// ReadReport describes how the included files were merged.
+type ReadReport struct {
+	// Conflicts lists the values that are both in a file and in the files it includes, as "path in url". The merge element of the file decides which one is used.
+	Conflicts []string
+}
+
+// ReadWithReport reads the contents at the url like ReadWithOptions and reports the values that were defined more than once.
+func ReadWithReport(ctx context.Context, url string, options ReadOptions) ([]byte, *ReadReport, error) {
+	result, err := readIncludes(ctx, url, options, false)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to open url %q", url)
+		return nil, nil, err
 	}
 
-	contents, err := ioutil.ReadAll(reader)
+	contents, err := result.bytes()
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to read from url %q", url)
+		return nil, nil, err
 	}
 
-	if err := reader.Close(); err != nil {
+	return contents, &ReadReport{Conflicts: result.conflicts}, nil
+}
+
+// ReadBuffer is like Read but returns the decrypted contents in a Buffer that can be destroyed after use. The copies of the values made while decrypting are not wiped, see Buffer.
+func ReadBuffer(url string) (*Buffer, error) {
+	return ReadBufferContext(context.Background(), url)
+}
+
+// ReadBufferContext is like ReadBuffer but gives up waiting for the url fetches and the key service when ctx is done.
+func ReadBufferContext(ctx context.Context, url string) (*Buffer, error) {
+	result, err := ReadContext(ctx, url)
+	if err != nil {
 		return nil, err
 	}
 
-	tree, header, err := decryptWithHeader(contents, false)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to decrypt url %q", url)
+	return &Buffer{b: result}, nil
+}
+
+// join concatenates the parts into a single slice and wipes them.
+func join(parts [][]byte) []byte {
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+
+	result := make([]byte, 0, size)
+	for _, part := range parts {
+		result = append(result, part...)
+		wipe(part)
 	}
 
-	removeHeader(tree)
+	return result
+}
 
-	text, err := FormatASTFile(tree)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to format contents of url %q", url)
+// readURL returns the contents at the specified URL. HTTP and HTTPS requests are cancelled when ctx is done. Other fetches cannot be cancelled, so readURL only stops waiting for them and leaves them to finish in the background.
+func readURL(ctx context.Context, url string) ([]byte, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return readHTTP(ctx, url)
 	}
 
-	var result bytes.Buffer
-	result.Write(text)
+	type result struct {
+		contents []byte
+		err      error
+	}
 
-	for _, name := range header.Include {
-		if strings.HasPrefix(name, "./") {
-			dir, _ := path.Split(url)
-			name = dir + "/" + name[2:]
+	done := make(chan result, 1)
+	go func() {
+		reader, err := urlreader.Open(url)
+		if err != nil {
+			done <- result{nil, errors.Wrapf(err, "failed to open url %q", url)}
+			return
 		}
 
-		fragment, err := Read(name)
+		contents, err := ioutil.ReadAll(reader)
+		closeErr := reader.Close()
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to include %q", name)
+			done <- result{nil, errors.Wrapf(err, "failed to read from url %q", url)}
+			return
 		}
 
-		result.WriteString("\n\n// " + name + "\n")
-		result.Write(fragment)
+		done <- result{contents, closeErr}
+	}()
+
+	select {
+	case r := <-done:
+		return r.contents, r.err
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "failed to read from url %q", url)
 	}
+}
 
-	return result.Bytes(), nil
+// readHTTP returns the body of a GET request for the url. The request is cancelled when ctx is done.
+func readHTTP(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open url %q", url)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open url %q", url)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &httpError{url: url, status: response.StatusCode}
+	}
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read from url %q", url)
+	}
+
+	return contents, nil
+}
+
+// httpError is returned when an HTTP request for a url does not succeed.
+type httpError struct {
+	url    string
+	status int
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("failed to open url %q: %d %s", e.url, e.status, http.StatusText(e.status))
+}
+
+// isNotFound tells if readURL failed because there is nothing at the url: a local file that does not exist, an HTTP 404, or an S3 object that does not exist.
+func isNotFound(err error) bool {
+	switch cause := errors.Cause(err).(type) {
+	case *httpError:
+		return cause.status == http.StatusNotFound
+	case awserr.Error:
+		return cause.Code() == "NoSuchKey" || cause.Code() == "NotFound"
+	default:
+		return os.IsNotExist(cause)
+	}
+}
+
+// getCipher returns the enc identifier for the cipher named in the header. A256GCM is used by default.
+func getCipher(name string) (string, error) {
+	switch name {
+	case "", A256GCM:
+		return A256GCM, nil
+	case C20P, XC20P, A256SIV:
+		return name, nil
+	default:
+		return "", fmt.Errorf("unsupported cipher: %+q", name)
+	}
 }
 
 func getKeyService(service ServiceParams) (KeyService, error) {
@@ -0,0 +1,403 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const sivConfig = `
+eh {
+	encrypted = false
+	key = ""
+	cipher = "A256SIV"
+	service {
+		type = "local"
+	}
+	protect = ["password"]
+}
+
+db {
+	password = "same-secret"
+}
+
+cache {
+	password = "same-secret"
+}
+`
+
+func TestDeterministicEncryptionIsStableAcrossRoundTrips(t *testing.T) {
+	first, err := Encrypt([]byte(sivConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	decrypted, err := Decrypt(first)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if !bytes.Contains(decrypted, []byte(`"same-secret"`)) {
+		t.Fatalf("expected decrypted contents to include the password, got:\n%s", decrypted)
+	}
+
+	second, err := Encrypt(decrypted)
+	if err != nil {
+		t.Fatal("failed to Encrypt again:", err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("expected the same ciphertext after re-encrypting, got:\n%s\n\nand:\n%s", first, second)
+	}
+
+	if bytes.Count(first, []byte("same-secret")) != 0 {
+		t.Errorf("expected passwords to be encrypted, got:\n%s", first)
+	}
+}
+
+func TestDeterministicEncryptionDependsOnPath(t *testing.T) {
+	key := &EncryptionKey{KID: "kid", Enc: A256SIV, RawKey: make([]byte, 32)}
+
+	db, err := key.encrypt([]byte("same-secret"), []byte("db.password"))
+	if err != nil {
+		t.Fatal("failed to encrypt:", err)
+	}
+
+	cache, err := key.encrypt([]byte("same-secret"), []byte("cache.password"))
+	if err != nil {
+		t.Fatal("failed to encrypt:", err)
+	}
+
+	if bytes.Equal(db, cache) {
+		t.Error("expected different ciphertexts for different paths")
+	}
+
+	if _, err := key.decrypt(db, []byte("cache.password")); err == nil {
+		t.Error("expected decrypt to fail for a value moved to a different path")
+	}
+}
+
+const reencryptConfig = `
+eh {
+	encrypted = false
+	key = ""
+	service {
+		type = "local"
+	}
+	protect = ["password"]
+}
+
+db {
+	password = "db-secret"
+}
+
+cache {
+	password = "cache-secret"
+}
+`
+
+func TestReencryptKeepsUnchangedCiphertexts(t *testing.T) {
+	previous, err := Encrypt([]byte(reencryptConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	decrypted, err := Decrypt(previous)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	edited := bytes.Replace(decrypted, []byte("cache-secret"), []byte("new-cache-secret"), 1)
+	result, err := Reencrypt(edited, previous)
+	if err != nil {
+		t.Fatal("failed to Reencrypt:", err)
+	}
+
+	previousLines := bytes.Split(previous, []byte("\n"))
+	resultLines := bytes.Split(result, []byte("\n"))
+	if len(previousLines) != len(resultLines) {
+		t.Fatalf("expected the same number of lines, got:\n%s\n\nand:\n%s", previous, result)
+	}
+
+	var changed []string
+	for i := range previousLines {
+		if !bytes.Equal(previousLines[i], resultLines[i]) {
+			changed = append(changed, string(bytes.TrimSpace(resultLines[i])))
+		}
+	}
+
+	if len(changed) != 1 || !bytes.HasPrefix([]byte(changed[0]), []byte("password")) {
+		t.Errorf("expected only the cache password to change, got %q", changed)
+	}
+
+	plaintext, err := Decrypt(result)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if !bytes.Contains(plaintext, []byte(`"new-cache-secret"`)) || !bytes.Contains(plaintext, []byte(`"db-secret"`)) {
+		t.Errorf("unexpected decrypted contents:\n%s", plaintext)
+	}
+}
+
+func TestReencryptDoesNotKeepCiphertextsOfAnotherCipher(t *testing.T) {
+	previous, err := Encrypt([]byte(reencryptConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	decrypted, err := Decrypt(previous)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	for _, setting := range []string{"cipher = \"XC20P\"", "compact = true"} {
+		edited := bytes.Replace(decrypted, []byte("encrypted = false"), []byte("encrypted = false\n\t"+setting), 1)
+		result, err := Reencrypt(edited, previous)
+		if err != nil {
+			t.Fatal("failed to Reencrypt:", err)
+		}
+
+		for _, line := range bytes.Split(previous, []byte("\n")) {
+			if bytes.HasPrefix(bytes.TrimSpace(line), []byte("password")) && bytes.Contains(result, line) {
+				t.Errorf("expected %s to encrypt the value again, got:\n%s", setting, result)
+			}
+		}
+
+		plaintext, err := Decrypt(result)
+		if err != nil || !bytes.Contains(plaintext, []byte(`"db-secret"`)) {
+			t.Errorf("failed to Decrypt with %s: %v\n%s", setting, err, plaintext)
+		}
+	}
+}
+
+const typedConfig = `eh {
+	encrypted = false
+	key       = ""
+
+	service {
+		type = "local"
+	}
+
+	protect = ["port", "ratio", "enabled", "keys", "tls"]
+}
+
+// db config
+db {
+	host    = "db.example.com"
+	port    = 5432
+	ratio   = 1.5
+	enabled = false
+	keys    = ["key-one", "key-two"]
+}
+
+// tls block
+tls {
+	// certificate
+	cert = "CERT"
+	key  = "KEY"
+
+	nested {
+		x = 1
+	}
+}
+
+// trailing comment
+other = "y"
+`
+
+func TestEncryptsNumbersBoolsListsAndBlocks(t *testing.T) {
+	encrypted, err := Encrypt([]byte(typedConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{"= 5432", "= 1.5", "= false", `"key-one"`, `"key-two"`, `"CERT"`, `"KEY"`, "certificate"} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if strings.TrimSpace(string(decrypted)) != strings.TrimSpace(typedConfig) {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
+
+func TestEncryptReportsUnsupportedProtectedValues(t *testing.T) {
+	for body, path := range map[string]string{
+		`servers = [{ password = "x" }]`:  "servers",
+		`service "db" { password = "x" }`: "service.db",
+	} {
+		contents := "eh {\n\tencrypted = false\n\tkey = \"\"\n\tservice {\n\t\ttype = \"local\"\n\t}\n\tprotect = [\"servers\", \"service\"]\n}\n\n" + body
+
+		encrypted, report, err := EncryptWithReport(context.Background(), []byte(contents), EncryptOptions{})
+		if err != nil {
+			t.Errorf("failed to EncryptWithReport %s: %v", body, err)
+		} else if !bytes.Contains(encrypted, []byte(`"x"`)) || len(report.Unsupported) != 1 || !strings.HasPrefix(report.Unsupported[0], path+" (") {
+			t.Errorf("expected %s to be left in plaintext and reported, got %q:\n%s", path, report.Unsupported, encrypted)
+		}
+
+		if _, _, err := EncryptWithReport(context.Background(), []byte(contents), EncryptOptions{Strict: true}); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("expected strict EncryptWithReport to fail for %s, got %v", body, err)
+		}
+	}
+}
+
+func TestEncryptDoesNotProtectLabels(t *testing.T) {
+	for _, syntax := range []string{"", "syntax = \"hcl2\""} {
+		contents := "eh {\n\tencrypted = false\n\tkey = \"\"\n\t" + syntax + "\n\tservice {\n\t\ttype = \"local\"\n\t}\n\tprotect = [\"password\", \"prod\"]\n}\n\n" +
+			"user \"password\" {\n\tname = \"x\"\n}\n\nenv \"prod\" {\n\thost = \"h\"\n}\n"
+
+		encrypted, err := Encrypt([]byte(contents))
+		if err != nil {
+			t.Fatalf("failed to Encrypt with %q: %v", syntax, err)
+		}
+
+		for _, plaintext := range []string{`"x"`, `"h"`} {
+			if !bytes.Contains(encrypted, []byte(plaintext)) {
+				t.Errorf("expected %s to be kept with %q, got:\n%s", plaintext, syntax, encrypted)
+			}
+		}
+	}
+}
+
+func TestEncryptReportsUnmatchedProtectedKeys(t *testing.T) {
+	contents := bytes.Replace([]byte(reencryptConfig), []byte(`protect = ["password"]`), []byte(`protect = ["password", "pasword"]`), 1)
+
+	_, report, err := EncryptWithReport(context.Background(), contents, EncryptOptions{})
+	if err != nil {
+		t.Fatal("failed to EncryptWithReport:", err)
+	}
+
+	if report.Protected["password"] != 2 {
+		t.Errorf("expected 2 protected passwords, got %d", report.Protected["password"])
+	}
+
+	if len(report.Unmatched) != 1 || report.Unmatched[0] != "pasword" {
+		t.Errorf("expected unmatched [pasword], got %q", report.Unmatched)
+	}
+
+	if _, _, err := EncryptWithReport(context.Background(), contents, EncryptOptions{Strict: true}); err == nil {
+		t.Error("expected strict EncryptWithReport to fail")
+	}
+}
+
+func TestDecryptKeepsPlaintextInsideProtectedBlocksOfOlderFiles(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/protected-block.hcl")
+	if err != nil {
+		t.Fatal("failed to read testdata:", err)
+	}
+
+	// an older version only encrypted the password, the key of the local service is not portable so the file is encrypted here
+	protect := []byte(`protect = ["aws", "password"]`)
+	encrypted, err := Encrypt(bytes.Replace(golden, protect, []byte(`protect = ["password"]`), 1))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+	encrypted = bytes.Replace(encrypted, []byte(`protect = ["password"]`), protect, 1)
+
+	if bytes.Contains(encrypted, []byte(`"secret"`)) || !bytes.Contains(encrypted, []byte(`key    = "x"`)) {
+		t.Fatalf("unexpected older file:\n%s", encrypted)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if strings.TrimSpace(string(decrypted)) != strings.TrimSpace(string(golden)) {
+		t.Errorf("expected decrypted contents to match testdata/protected-block.hcl, got:\n%s", decrypted)
+	}
+}
+
+func TestHeredocsRoundTripExactly(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/heredoc.hcl")
+	if err != nil {
+		t.Fatal("failed to read testdata:", err)
+	}
+
+	encrypted, err := Encrypt(golden)
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{"BEGIN RSA PRIVATE KEY", "BEGIN CERTIFICATE", "keeps blank lines"} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	for _, marker := range []string{"<<EOF\n", "<<-CERTIFICATE\n", "<<SCRIPT\n"} {
+		if !bytes.Contains(encrypted, []byte(marker)) {
+			t.Errorf("expected marker %q to be kept, got:\n%s", marker, encrypted)
+		}
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if strings.TrimSpace(string(decrypted)) != strings.TrimSpace(string(golden)) {
+		t.Errorf("expected decrypted contents to match testdata/heredoc.hcl, got:\n%s", decrypted)
+	}
+}
+
+const annotatedConfig = `eh {
+	encrypted = false
+	key       = ""
+
+	service {
+		type = "local"
+	}
+
+	protect = []
+}
+
+db {
+	host     = "db.example.com"
+	password = "secret"         // eh:protect
+}
+
+# eh:protect
+tls {
+	cert = "CERT"
+}
+`
+
+func TestEncryptsAnnotatedValues(t *testing.T) {
+	encrypted, report, err := EncryptWithReport(context.Background(), []byte(annotatedConfig), EncryptOptions{})
+	if err != nil {
+		t.Fatal("failed to EncryptWithReport:", err)
+	}
+
+	for _, plaintext := range []string{`"secret"`, `"CERT"`} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	if !bytes.Contains(encrypted, []byte(`"db.example.com"`)) || bytes.Count(encrypted, []byte("eh:protect")) != 2 {
+		t.Errorf("expected host and annotations to be kept, got:\n%s", encrypted)
+	}
+
+	if report.Protected["password"] != 1 || report.Protected["tls"] != 1 {
+		t.Errorf("expected annotated values to be counted, got %v", report.Protected)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if strings.TrimSpace(string(decrypted)) != strings.TrimSpace(annotatedConfig) {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
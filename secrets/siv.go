@@ -0,0 +1,175 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// siv implements the deterministic AES-SIV construction from RFC 5297. It satisfies cipher.AEAD with a zero nonce size: the synthetic IV is derived from the additional data and the plaintext, and is prepended to the ciphertext.
+type siv struct {
+	mac cipher.Block
+	ctr cipher.Block
+}
+
+const sivSize = aes.BlockSize
+
+// newSIV creates AES-SIV with a key that is twice the size of an AES key. The first half is used for S2V, the second half for CTR.
+func newSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 && len(key) != 48 && len(key) != 64 {
+		return nil, fmt.Errorf("invalid AES-SIV key length: %d", len(key))
+	}
+
+	mac, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &siv{mac: mac, ctr: ctr}, nil
+}
+
+func (s *siv) NonceSize() int {
+	return 0
+}
+
+func (s *siv) Overhead() int {
+	return sivSize
+}
+
+func (s *siv) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != 0 {
+		panic("secrets: AES-SIV does not use a nonce")
+	}
+
+	v := s.s2v(additionalData, plaintext)
+
+	ret, out := sliceForAppend(dst, sivSize+len(plaintext))
+	copy(out, v)
+	s.xorCTR(out[sivSize:], plaintext, v)
+	return ret
+}
+
+func (s *siv) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != 0 {
+		return nil, errors.New("AES-SIV does not use a nonce")
+	}
+
+	if len(ciphertext) < sivSize {
+		return nil, errors.New("AES-SIV ciphertext is too short")
+	}
+
+	v := ciphertext[:sivSize]
+	ret, out := sliceForAppend(dst, len(ciphertext)-sivSize)
+	s.xorCTR(out, ciphertext[sivSize:], v)
+
+	if subtle.ConstantTimeCompare(v, s.s2v(additionalData, out)) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errors.New("AES-SIV message authentication failed")
+	}
+
+	return ret, nil
+}
+
+// s2v computes the synthetic IV for a single additional data string and the plaintext.
+func (s *siv) s2v(additionalData, plaintext []byte) []byte {
+	d := s.cmac(make([]byte, sivSize))
+	dbl(d)
+	xorBytes(d, s.cmac(additionalData))
+
+	var t []byte
+	if len(plaintext) >= sivSize {
+		t = make([]byte, len(plaintext))
+		copy(t, plaintext)
+		xorBytes(t[len(t)-sivSize:], d)
+	} else {
+		dbl(d)
+		t = make([]byte, sivSize)
+		copy(t, plaintext)
+		t[len(plaintext)] = 0x80
+		xorBytes(t, d)
+	}
+
+	v := s.cmac(t)
+	wipe(t)
+	return v
+}
+
+func (s *siv) xorCTR(dst, src, v []byte) {
+	iv := make([]byte, sivSize)
+	copy(iv, v)
+	iv[8] &= 0x7f
+	iv[12] &= 0x7f
+
+	cipher.NewCTR(s.ctr, iv).XORKeyStream(dst, src)
+}
+
+// cmac computes AES-CMAC (RFC 4493) of the message.
+func (s *siv) cmac(message []byte) []byte {
+	k1 := make([]byte, sivSize)
+	s.mac.Encrypt(k1, k1)
+	dbl(k1)
+
+	last := make([]byte, sivSize)
+	n := len(message)
+	if n > 0 && n%sivSize == 0 {
+		copy(last, message[n-sivSize:])
+		xorBytes(last, k1)
+		n -= sivSize
+	} else {
+		k2 := make([]byte, sivSize)
+		copy(k2, k1)
+		dbl(k2)
+
+		rest := n % sivSize
+		copy(last, message[n-rest:])
+		last[rest] = 0x80
+		xorBytes(last, k2)
+		n -= rest
+	}
+
+	x := make([]byte, sivSize)
+	for i := 0; i < n; i += sivSize {
+		xorBytes(x, message[i:i+sivSize])
+		s.mac.Encrypt(x, x)
+	}
+
+	xorBytes(x, last)
+	s.mac.Encrypt(x, x)
+	return x
+}
+
+// dbl multiplies the block by x in GF(2^128).
+func dbl(b []byte) {
+	carry := b[0] >> 7
+	for i := 0; i < len(b)-1; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[len(b)-1] = b[len(b)-1]<<1 ^ 0x87*carry
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,38 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSIVMatchesRFC5297(t *testing.T) {
+	// RFC 5297, Appendix A.1
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	ad, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext, _ := hex.DecodeString("112233445566778899aabbccddee")
+	expected, _ := hex.DecodeString("85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c")
+
+	aead, err := newSIV(key)
+	if err != nil {
+		t.Fatal("failed to create SIV:", err)
+	}
+
+	ciphertext := aead.Seal(nil, nil, plaintext, ad)
+	if !bytes.Equal(ciphertext, expected) {
+		t.Fatalf("expected %x, got %x", expected, ciphertext)
+	}
+
+	opened, err := aead.Open(nil, nil, ciphertext, ad)
+	if err != nil {
+		t.Fatal("failed to Open:", err)
+	}
+
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("expected %x, got %x", plaintext, opened)
+	}
+
+	if _, err := aead.Open(nil, nil, ciphertext, []byte("other")); err == nil {
+		t.Error("expected Open to fail with different additional data")
+	}
+}
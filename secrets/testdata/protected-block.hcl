@@ -0,0 +1,20 @@
+eh {
+	encrypted = false
+	key       = ""
+
+	service {
+		type = "local"
+	}
+
+	protect = ["aws", "password"]
+}
+
+// versions before whole blocks could be protected left the values inside a protected block in plaintext
+aws {
+	key    = "x"
+	region = "us-east-1"
+}
+
+db {
+	password = "secret"
+}
@@ -0,0 +1,457 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"github.com/pkg/errors"
+)
+
+const formatTOML = "toml"
+
+// Protected TOML dates and times, and strings that cannot be written again from their value, are encrypted as their text.
+const valueTypeTOML = "toml"
+
+// tomlTypes maps the kinds of protected values that are not strings to their value types.
+var tomlTypes = map[unstable.Kind]string{
+	unstable.Integer:       ValueNumber,
+	unstable.Float:         ValueFloat,
+	unstable.Bool:          ValueBool,
+	unstable.LocalDate:     valueTypeTOML,
+	unstable.LocalTime:     valueTypeTOML,
+	unstable.LocalDateTime: valueTypeTOML,
+	unstable.DateTime:      valueTypeTOML,
+}
+
+// tomlDocument edits the original text, which keeps comments and the layout.
+type tomlDocument struct {
+	text   []byte
+	header *Header
+	values []*tomlValue
+
+	// tables are the positions of the table headers, in order
+	tables []tomlTable
+	edits  []textEdit
+}
+
+// tomlValue is a string, number, boolean or date and its position in the text.
+type tomlValue struct {
+	doc *tomlDocument
+
+	// names are the keys of the tables and the key of the value
+	names []string
+	path  string
+
+	kind       unstable.Kind
+	start, end int
+	value      string
+}
+
+// tomlTable is the position of a table header such as [eh.service].
+type tomlTable struct {
+	names []string
+	start int
+}
+
+// tomlFormat is the TOML format. It accepts contents with an [eh] table.
+type tomlFormat struct{}
+
+func (tomlFormat) Name() string {
+	return formatTOML
+}
+
+func (tomlFormat) Extensions() []string {
+	return []string{".toml"}
+}
+
+func (tomlFormat) Detect(contents []byte) bool {
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "[eh]" || strings.HasPrefix(line, "[eh.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse parses the contents as TOML and decodes its 'eh' table.
+func (tomlFormat) Parse(contents []byte) (Document, error) {
+	var wrapper struct {
+		Header map[string]interface{} `toml:"eh"`
+	}
+
+	if err := toml.Unmarshal(contents, &wrapper); err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' table")
+	}
+
+	if wrapper.Header == nil {
+		return nil, errors.New("failed, must have 'eh' table")
+	}
+
+	// the header is decoded from JSON because includes can be strings and tables
+	encoded, err := json.Marshal(wrapper.Header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' table")
+	}
+
+	var header Header
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' table")
+	}
+
+	d, err := parseTOML(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	d.header = &header
+	return d, nil
+}
+
+// parseTOML records the tables and values of a copy of the contents.
+func parseTOML(contents []byte) (*tomlDocument, error) {
+	d := &tomlDocument{text: append([]byte(nil), contents...)}
+
+	var p unstable.Parser
+	p.Reset(d.text)
+
+	var table []string
+	for p.NextExpression() {
+		e := p.Expression()
+		switch e.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table = tomlKeys(e.Key())
+
+			first := e.Key()
+			first.Next()
+			start := bytes.LastIndexByte(d.text[:first.Node().Raw.Offset], '[')
+			if e.Kind == unstable.ArrayTable {
+				start--
+			}
+			d.tables = append(d.tables, tomlTable{names: table, start: start})
+		case unstable.KeyValue:
+			names := append(append([]string(nil), table...), tomlKeys(e.Key())...)
+			d.addValues(&p, names, e.Value())
+		}
+	}
+
+	if err := p.Error(); err != nil {
+		return nil, errors.Wrap(err, "failed to parse TOML")
+	}
+
+	return d, nil
+}
+
+// addValues records the value, or the values inside arrays and inline tables.
+func (d *tomlDocument) addValues(p *unstable.Parser, names []string, node *unstable.Node) {
+	switch node.Kind {
+	case unstable.Array:
+		items := node.Children()
+		for items.Next() {
+			d.addValues(p, names, items.Node())
+		}
+	case unstable.InlineTable:
+		members := node.Children()
+		for members.Next() {
+			member := members.Node()
+			d.addValues(p, append(append([]string(nil), names...), tomlKeys(member.Key())...), member.Value())
+		}
+	default:
+		raw := node.Raw
+		if raw.Length == 0 {
+			// booleans and dates only refer to their text
+			raw = p.Range(node.Data)
+		}
+
+		d.values = append(d.values, &tomlValue{
+			doc:   d,
+			names: names,
+			path:  strings.Join(names, "."),
+			kind:  node.Kind,
+			start: int(raw.Offset),
+			end:   int(raw.Offset + raw.Length),
+			value: string(node.Data),
+		})
+	}
+}
+
+func tomlKeys(keys unstable.Iterator) []string {
+	var names []string
+	for keys.Next() {
+		names = append(names, string(keys.Node().Data))
+	}
+
+	return names
+}
+
+func (d *tomlDocument) Header() (*Header, error) {
+	return d.header, nil
+}
+
+// Walk walks the values outside of the 'eh' table. Table keys are names of the values inside.
+func (d *tomlDocument) Walk(fn func(Value) error) error {
+	for _, v := range d.values {
+		if v.names[0] == "eh" {
+			// do not process eh element
+			continue
+		}
+
+		if err := fn(v); err != nil && err != SkipValue {
+			return errors.Wrapf(err, "failed to process %q", v.path)
+		}
+	}
+
+	return nil
+}
+
+func (v *tomlValue) Names() []string {
+	return v.names
+}
+
+func (v *tomlValue) Path() string {
+	return v.path
+}
+
+func (v *tomlValue) Get() (string, string, error) {
+	if v.kind == unstable.String {
+		return v.value, ValueString, nil
+	}
+
+	valueType, ok := tomlTypes[v.kind]
+	if !ok {
+		return "", "", &UnsupportedError{Reason: fmt.Sprintf("%s value", v.kind)}
+	}
+
+	return string(v.doc.text[v.start:v.end]), valueType, nil
+}
+
+// Raw returns the text of a string that is not written the same way again, such as a multi-line literal string.
+func (v *tomlValue) Raw() (string, string, bool) {
+	raw := v.doc.text[v.start:v.end]
+	if v.kind != unstable.String || bytes.Equal(tomlQuote(v.value, raw), raw) {
+		return "", "", false
+	}
+
+	return string(raw), valueTypeTOML, true
+}
+
+func (v *tomlValue) Set(text string, valueType string) error {
+	if valueType == ValueString {
+		v.doc.replace(v, tomlQuote(text, v.doc.text[v.start:v.end]))
+	} else {
+		v.doc.replace(v, []byte(text))
+	}
+
+	return nil
+}
+
+func (d *tomlDocument) replace(v *tomlValue, text []byte) {
+	d.edits = append(d.edits, textEdit{start: v.start, end: v.end, text: text})
+}
+
+// tomlQuote returns the string in the quotes of the literal string it replaces if possible, or as a basic string.
+func tomlQuote(s string, previous []byte) []byte {
+	// literal strings have no escapes
+	literal := !strings.ContainsAny(s, "'\r") && strings.IndexFunc(s, func(r rune) bool {
+		return (r < 0x20 && r != '\t' && r != '\n') || r == 0x7f
+	}) < 0
+
+	switch {
+	case bytes.HasPrefix(previous, []byte("'''")) && literal && !strings.HasPrefix(s, "\n"):
+		return []byte("'''" + s + "'''")
+	case bytes.HasPrefix(previous, []byte("'")) && literal && !strings.Contains(s, "\n"):
+		return []byte("'" + s + "'")
+	}
+
+	return tomlString(s)
+}
+
+// tomlString returns the TOML basic string, or the multi-line basic string if s has several lines.
+func tomlString(s string) []byte {
+	multiline := strings.Contains(s, "\n")
+
+	var b bytes.Buffer
+	if multiline {
+		b.WriteString("\"\"\"\n")
+	} else {
+		b.WriteByte('"')
+	}
+
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' && multiline:
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	if multiline {
+		b.WriteString("\"\"\"")
+	} else {
+		b.WriteByte('"')
+	}
+
+	return b.Bytes()
+}
+
+func (d *tomlDocument) SetHeaderValue(name string, value interface{}) error {
+	var text []byte
+	switch v := value.(type) {
+	case string:
+		text = tomlString(v)
+	case bool:
+		text = []byte(strconv.FormatBool(v))
+	default:
+		return fmt.Errorf("failed, unsupported value of %q: %v", name, value)
+	}
+
+	for _, v := range d.values {
+		if v.path == "eh."+name {
+			d.replace(v, text)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed, no %q element found in 'eh'", name)
+}
+
+// removeHeader removes the [eh] table and its sub-tables.
+func (d *tomlDocument) RemoveHeader() error {
+	var removals []textEdit
+	for i, table := range d.tables {
+		if table.names[0] != "eh" {
+			continue
+		}
+
+		end := len(d.text)
+		if i+1 < len(d.tables) {
+			end = d.tables[i+1].start
+		}
+
+		removals = append(removals, textEdit{start: table.start, end: end})
+	}
+
+	d.edits = removeEdits(d.edits, removals)
+	return nil
+}
+
+// Merge decodes both documents and writes the merged tables as a new document without comments.
+func (d *tomlDocument) Merge(fragment Document, resolve func(path string) bool) error {
+	f, ok := fragment.(*tomlDocument)
+	if !ok {
+		return errNotMergeable
+	}
+
+	values, err := d.decode()
+	if err != nil {
+		return err
+	}
+
+	fragmentValues, err := f.decode()
+	if err != nil {
+		return err
+	}
+
+	mergeTOMLTables(values, fragmentValues, "", resolve)
+
+	text, err := toml.Marshal(values)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode merged TOML")
+	}
+	defer wipe(text)
+
+	merged, err := parseTOML(text)
+	if err != nil {
+		return err
+	}
+
+	d.text, d.values, d.tables, d.edits = merged.text, merged.values, merged.tables, nil
+	for _, v := range d.values {
+		v.doc = d
+	}
+
+	return nil
+}
+
+func (d *tomlDocument) decode() (map[string]interface{}, error) {
+	text, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(text)
+
+	values := make(map[string]interface{})
+	if err := toml.Unmarshal(text, &values); err != nil {
+		return nil, errors.Wrap(err, "failed to decode TOML")
+	}
+
+	return values, nil
+}
+
+func mergeTOMLTables(table map[string]interface{}, fragment map[string]interface{}, path string, resolve func(path string) bool) {
+	keys := make([]string, 0, len(fragment))
+	for key := range fragment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fragment[key]
+		existing, ok := table[key]
+		if !ok {
+			table[key] = value
+			continue
+		}
+
+		subtable, isTable := existing.(map[string]interface{})
+		fragmentSubtable, isFragmentTable := value.(map[string]interface{})
+		if isTable && isFragmentTable {
+			mergeTOMLTables(subtable, fragmentSubtable, joinPath(path, key), resolve)
+			continue
+		}
+
+		if !reflect.DeepEqual(existing, value) && resolve(joinPath(path, key)) {
+			table[key] = value
+		}
+	}
+}
+
+func (d *tomlDocument) Bytes() ([]byte, error) {
+	return applyEdits(d.text, d.edits)
+}
+
+// Decode decodes the document with go-toml and adds the key of the value to errors.
+func (d *tomlDocument) Decode(out interface{}) error {
+	text, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(text)
+
+	err = toml.Unmarshal(text, out)
+	if decodeErr, ok := err.(*toml.DecodeError); ok && len(decodeErr.Key()) > 0 {
+		return &DecodeError{Path: strings.Join(decodeErr.Key(), "."), Err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+const tomlConfig = `# service configuration
+name = "app"
+
+[eh]
+encrypted = false
+key = ""
+protect = ["password", "port", "tls", "tokens"]
+
+[eh.service]
+type = "local"
+
+[db]
+user = "admin"
+password = "correct \"horse\"" # rotated monthly
+port = 5432
+
+[tls]
+cert = """
+-----BEGIN CERTIFICATE-----
+MIIBkTCB+wIJAKHHIG2eE5rmMA0GCSqGSIb3DQEBCwUAMBExDzANBgNVBAMMBnVu
+-----END CERTIFICATE-----"""
+expires = 2027-01-01
+
+[[replicas]]
+host = "a.example.com"
+tokens = ["token-one", "token-two"]
+`
+
+func TestTOMLRoundTrip(t *testing.T) {
+	encrypted, err := Encrypt([]byte(tomlConfig))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{"horse", "5432", "BEGIN CERTIFICATE", "2027-01-01", "token-one", "token-two"} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	for _, kept := range []string{"# service configuration", "# rotated monthly", "[[replicas]]", `host = "a.example.com"`, "encrypted = true"} {
+		if !bytes.Contains(encrypted, []byte(kept)) {
+			t.Errorf("expected %q to be kept, got:\n%s", kept, encrypted)
+		}
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if string(decrypted) != tomlConfig {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
+
+func TestTOMLRestoresStringQuotes(t *testing.T) {
+	config := `[eh]
+encrypted = false
+key = ""
+protect = ["path", "pattern", "script", "note"]
+
+[eh.service]
+type = "local"
+
+[app]
+path = 'C:\Users\app'
+pattern = '''
+^\d+$'''
+script = """first \
+  second"""
+note = "tab\there"
+`
+
+	encrypted, err := Encrypt([]byte(config))
+	if err != nil {
+		t.Fatal("failed to Encrypt:", err)
+	}
+
+	for _, plaintext := range []string{`Users`, `\d+`, "second", `tab\there`} {
+		if bytes.Contains(encrypted, []byte(plaintext)) {
+			t.Errorf("expected %q to be encrypted, got:\n%s", plaintext, encrypted)
+		}
+	}
+
+	if !bytes.Contains(encrypted, []byte("path = 'ey")) {
+		t.Errorf("expected the literal string to stay literal, got:\n%s", encrypted)
+	}
+
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal("failed to Decrypt:", err)
+	}
+
+	if string(decrypted) != config {
+		t.Errorf("expected decrypted contents to match the original, got:\n%s", decrypted)
+	}
+}
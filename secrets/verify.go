@@ -0,0 +1,231 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secretTag is the struct tag that marks the fields of config types whose values must be encrypted, as in `eh:"secret"`.
+const secretTag = "eh"
+
+// FieldKey returns the key of the struct field in config files: the name in its hcl, json, yaml or toml tag, or else the lowercase name of the field. It returns "-" for fields that are skipped, and "" for embedded structs without a name, whose fields are at the same level. secret tells if the field is tagged with `eh:"secret"` or holds Secret values.
+func FieldKey(field reflect.StructField) (key string, secret bool) {
+	secret = field.Tag.Get(secretTag) == "secret"
+	for t := field.Type; t != nil && !secret; t = t.Elem() {
+		secret = t == secretType
+		if k := t.Kind(); k != reflect.Ptr && k != reflect.Slice && k != reflect.Array && k != reflect.Map {
+			break
+		}
+	}
+	for _, tag := range []string{"hcl", "json", "yaml", "toml"} {
+		if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" {
+			return name, secret
+		}
+	}
+
+	if field.Anonymous {
+		return "", secret
+	}
+
+	return strings.ToLower(field.Name), secret
+}
+
+// secretPaths returns the keys from the top of the config down to every secret field of the type. Maps match any key, which is "*" in the path.
+func secretPaths(t reflect.Type, path []string, visited map[reflect.Type]bool) [][]string {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Map {
+		return secretPaths(t.Elem(), append(path[:len(path):len(path)], "*"), visited)
+	}
+
+	if t.Kind() != reflect.Struct || visited[t] {
+		return nil
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	var result [][]string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, secret := FieldKey(field)
+		if key == "-" {
+			continue
+		}
+
+		fieldPath := path
+		if key != "" {
+			fieldPath = append(path[:len(path):len(path)], key)
+		}
+
+		if secret && key != "" {
+			result = append(result, fieldPath)
+			continue
+		}
+
+		result = append(result, secretPaths(field.Type, fieldPath, visited)...)
+	}
+
+	return result
+}
+
+// ProtectList returns the keys of the fields of v, a config struct or a pointer to one, that are tagged with `eh:"secret"`, sorted and without duplicates. It can be used as the protect list of the config files that are decoded into v.
+func ProtectList(v interface{}) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, path := range secretPaths(reflect.TypeOf(v), nil, map[reflect.Type]bool{}) {
+		name := path[len(path)-1]
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Verify checks that every value of the file at the url and of its included files that is decoded into a field of v tagged with `eh:"secret"` is encrypted. The files are not decrypted, so the key service is not needed.
+func Verify(url string, v interface{}) error {
+	return VerifyContext(context.Background(), url, v)
+}
+
+// VerifyContext is like Verify but gives up waiting for the url fetches when ctx is done.
+func VerifyContext(ctx context.Context, url string, v interface{}) error {
+	paths := secretPaths(reflect.TypeOf(v), nil, map[reflect.Type]bool{})
+
+	var plaintext []string
+	seen := make(map[string]bool)
+	if err := verifyURL(ctx, url, false, paths, seen, &plaintext); err != nil {
+		return err
+	}
+
+	if len(plaintext) > 0 {
+		return fmt.Errorf("failed, secret values are not encrypted: %s", strings.Join(plaintext, ", "))
+	}
+
+	return nil
+}
+
+// verifyURL adds the secret values of the url and its includes that are not encrypted to plaintext. An optional url that does not exist is skipped.
+func verifyURL(ctx context.Context, url string, optional bool, paths [][]string, seen map[string]bool, plaintext *[]string) error {
+	canonical, err := canonicalURL(url)
+	if err != nil {
+		return err
+	}
+
+	if seen[canonical] {
+		return nil
+	}
+	seen[canonical] = true
+
+	contents, err := readURL(ctx, url)
+	if err != nil && optional && isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, doc, header, err := parseDocument(url, contents)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse url %q", url)
+	}
+
+	// values are protected the same way as they are decrypted
+	p := newProcessor(opDecrypt, nil, header.Protect)
+	err = doc.Walk(func(v Value) error {
+		keys := v.Names()
+		if l, ok := v.(LabeledValue); ok {
+			keys = l.Keys()
+		}
+
+		if !isSecretValue(keys, paths) {
+			return nil
+		}
+
+		protected := p.protectedName(v.Names()) != ""
+		if a, ok := v.(AnnotatedValue); ok && a.Annotated() {
+			protected = true
+		}
+
+		text, valueType, err := v.Get()
+		if _, ok := errors.Cause(err).(*UnsupportedError); ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Encrypted && protected && valueType == ValueString && isCiphertext(text) {
+			return SkipValue
+		}
+
+		switch valueType {
+		case valueTypeBlock, valueTypeJSON, valueTypeYAML:
+			// the values inside are checked one by one
+			return nil
+		}
+
+		*plaintext = append(*plaintext, fmt.Sprintf("%s in %s", v.Path(), url))
+		return SkipValue
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to verify url %q", url)
+	}
+
+	locations, err := includeLocations(url, header)
+	if err != nil {
+		return err
+	}
+
+	for _, location := range locations {
+		if err := verifyURL(ctx, location.url, location.optional, paths, seen, plaintext); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isSecretValue tells if the keys of a value match one of the paths of secret fields, or start with one of them. Keys are matched without case, as by the decoders.
+func isSecretValue(keys []string, paths [][]string) bool {
+	for _, path := range paths {
+		if len(keys) < len(path) {
+			continue
+		}
+
+		match := true
+		for i, key := range path {
+			if key != "*" && !strings.EqualFold(key, keys[i]) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCiphertext tells if the text of a value is an encrypted value, in either encoding.
+func isCiphertext(text string) bool {
+	_, encoded := splitValueType(strings.TrimSpace(text))
+	if isCompact(encoded) {
+		return true
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	return err == nil && len(decoded) > 0 && decoded[0] == '{' && json.Valid(decoded)
+}
@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type verifiedDB struct {
+	Host     string `hcl:"host"`
+	Password string `hcl:"password" eh:"secret"`
+}
+
+type verifiedCredentials struct {
+	Token string `eh:"secret"`
+}
+
+type verifiedConfig struct {
+	DB       verifiedDB                     `hcl:"db"`
+	Services map[string]verifiedCredentials `hcl:"services"`
+	TLS      struct{ Key string }           `hcl:"tls" eh:"secret"`
+	Ignored  string                         `hcl:"-" eh:"secret"`
+	verifiedEmbedded
+}
+
+type verifiedEmbedded struct {
+	APIKey string `json:"api_key" eh:"secret"`
+}
+
+func TestProtectList(t *testing.T) {
+	expected := []string{"api_key", "password", "tls", "token"}
+	if names := ProtectList(&verifiedConfig{}); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const config = `eh {
+	encrypted = false
+	key = ""
+	service {
+		type = "local"
+	}
+	protect = [PROTECT]
+	include = ["./shared.hcl"]
+}
+
+db {
+	host = "localhost"
+	password = "secret"
+}
+
+services {
+	mail {
+		token = "mail-token"
+	}
+}
+
+tls {
+	key = "KEY"
+}
+
+api_key = "api"
+`
+
+	const shared = `eh {
+	encrypted = false
+	key = ""
+	service {
+		type = "local"
+	}
+	protect = ["token"]
+}
+
+services {
+	chat {
+		token = "chat-token"
+	}
+}
+`
+
+	complete, err := Encrypt([]byte(strings.Replace(config, "PROTECT", `"password", "token", "tls", "api_key"`, 1)))
+	if err != nil {
+		t.Fatalf("failed to Encrypt: %v", err)
+	}
+
+	partial, err := Encrypt([]byte(strings.Replace(config, "PROTECT", `"password"`, 1)))
+	if err != nil {
+		t.Fatalf("failed to Encrypt: %v", err)
+	}
+
+	encryptedShared, err := Encrypt([]byte(shared))
+	if err != nil {
+		t.Fatalf("failed to Encrypt: %v", err)
+	}
+
+	dir := writeFiles(t, map[string]string{
+		"complete.hcl": string(complete),
+		"partial.hcl":  string(partial),
+		"plain.hcl":    strings.Replace(config, "PROTECT", `"password", "token", "tls", "api_key"`, 1),
+		"shared.hcl":   string(encryptedShared),
+	})
+	defer os.RemoveAll(dir)
+
+	var cfg verifiedConfig
+	if err := Verify(filepath.Join(dir, "complete.hcl"), &cfg); err != nil {
+		t.Errorf("failed to Verify encrypted file: %v", err)
+	}
+
+	err = Verify(filepath.Join(dir, "partial.hcl"), &cfg)
+	if err == nil {
+		t.Fatal("expected Verify of partially encrypted file to fail")
+	}
+
+	for _, path := range []string{"services.mail.token", "tls.key", "api_key"} {
+		if !strings.Contains(err.Error(), path+" in ") {
+			t.Errorf("expected %s to be reported, got %v", path, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "password") || strings.Contains(err.Error(), "host") || strings.Contains(err.Error(), "chat") {
+		t.Errorf("expected only plaintext secrets to be reported, got %v", err)
+	}
+
+	err = Verify(filepath.Join(dir, "plain.hcl"), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "db.password in ") {
+		t.Errorf("expected Verify of decrypted file to fail, got %v", err)
+	}
+}
+
+func TestVerifyLabeledBlocks(t *testing.T) {
+	type config struct {
+		Services map[string]verifiedCredentials            `hcl:"services"`
+		Teams    map[string]map[string]verifiedCredentials `hcl:"teams"`
+	}
+
+	const header = "eh {\n\tencrypted = false\n\tkey = \"\"\n\tSYNTAX\n\tservice {\n\t\ttype = \"local\"\n\t}\n}\n\n"
+	dir := writeFiles(t, map[string]string{
+		"config.hcl": strings.Replace(header, "SYNTAX", "", 1) + `services "mail" {
+	token = "mail-token"
+}
+
+teams "ops" "pager" {
+	token = "pager-token"
+}
+
+teams {
+	dev {
+		ci {
+			token = "ci-token"
+		}
+	}
+}
+`,
+		"config.tf": strings.Replace(header, "SYNTAX", `syntax = "hcl2"`, 1) + `services "mail" {
+	token = "mail-token"
+}
+
+teams "ops" "pager" {
+	token = "pager-token"
+}
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	for name, paths := range map[string][]string{
+		"config.hcl": {"services.mail.token", "teams.ops.pager.token", "teams.dev.ci.token"},
+		"config.tf":  {"services.mail.token", "teams.ops.pager.token"},
+	} {
+		err := Verify(filepath.Join(dir, name), &config{})
+		for _, path := range paths {
+			if err == nil || !strings.Contains(err.Error(), path+" in ") {
+				t.Errorf("expected %s of %s to be reported, got %v", path, name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,889 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const formatYAML = "yaml"
+
+// Protected YAML mappings are encrypted as their text, including their comments.
+const valueTypeYAML = "yaml"
+
+// yamlTypes maps the tags of protected scalars that are not strings to their value types.
+var yamlTypes = map[string]string{
+	"!!int":   ValueNumber,
+	"!!float": ValueFloat,
+	"!!bool":  ValueBool,
+}
+
+// yamlFormat accepts contents with a top-level 'eh' mapping key.
+type yamlFormat struct{}
+
+func (yamlFormat) Name() string {
+	return formatYAML
+}
+
+func (yamlFormat) Extensions() []string {
+	return []string{".yaml", ".yml"}
+}
+
+func (yamlFormat) Detect(contents []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(nil, len(contents)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "eh:" || strings.HasPrefix(line, "eh: ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse parses the contents as YAML and decodes its top-level 'eh' mapping.
+func (yamlFormat) Parse(contents []byte) (Document, error) {
+	d, err := parseYAML(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	eh, _, err := d.headerNode()
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	if err := eh.Decode(&values); err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' mapping")
+	}
+
+	// yaml.v3 matches the lowercase field names exactly, encoding/json ignores case
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' mapping")
+	}
+
+	d.header = &Header{}
+	if err := json.Unmarshal(encoded, d.header); err != nil {
+		return nil, errors.Wrap(err, "failed to decode 'eh' mapping")
+	}
+
+	return d, nil
+}
+
+// parseYAML parses a copy of the contents, which may be wiped by the caller.
+func parseYAML(contents []byte) (*yamlDocument, error) {
+	text := append([]byte(nil), contents...)
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(text, &root); err != nil {
+		return nil, errors.Wrap(err, "failed to parse YAML")
+	}
+
+	d := &yamlDocument{text: text, root: &root, lines: []int{0}}
+	for i, c := range text {
+		if c == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	d.collectAliases(&root)
+	return d, nil
+}
+
+// yamlDocument edits the original text, which keeps comments, anchors and the layout. The nodes of yaml.v3 only give the positions.
+type yamlDocument struct {
+	text   []byte
+	root   *yaml.Node
+	header *Header
+	edits  []textEdit
+
+	// lines are the offsets where the lines of the text start
+	lines []int
+
+	// aliases are the positions of the aliases and of the nodes they refer to
+	aliases []yamlAlias
+}
+
+type yamlAlias struct {
+	name        string
+	pos, target int
+}
+
+func (d *yamlDocument) collectAliases(node *yaml.Node) {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		d.aliases = append(d.aliases, yamlAlias{name: node.Value, pos: d.offset(node), target: d.offset(node.Alias)})
+	}
+
+	for _, child := range node.Content {
+		d.collectAliases(child)
+	}
+}
+
+// offset returns the position of the node in the text. The columns of yaml.v3 count characters.
+func (d *yamlDocument) offset(node *yaml.Node) int {
+	if node.Line < 1 || node.Line > len(d.lines) {
+		return len(d.text)
+	}
+
+	pos := d.lines[node.Line-1]
+	for i := 1; i < node.Column && pos < len(d.text); i++ {
+		_, size := utf8.DecodeRune(d.text[pos:])
+		pos += size
+	}
+
+	return pos
+}
+
+// mapping returns the top-level mapping of the document.
+func (d *yamlDocument) mapping() (*yaml.Node, error) {
+	node := d.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) == 1 {
+		node = node.Content[0]
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.New("failed, unexpected YAML format")
+	}
+
+	return node, nil
+}
+
+// headerNode returns the 'eh' mapping and its key.
+func (d *yamlDocument) headerNode() (*yaml.Node, *yaml.Node, error) {
+	mapping, err := d.mapping()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "eh" && mapping.Content[i+1].Kind == yaml.MappingNode {
+			return mapping.Content[i+1], mapping.Content[i], nil
+		}
+	}
+
+	return nil, nil, errors.New("failed, must have 'eh' mapping")
+}
+
+func (d *yamlDocument) Header() (*Header, error) {
+	return d.header, nil
+}
+
+func (d *yamlDocument) Walk(fn func(Value) error) error {
+	mapping, err := d.mapping()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		name := mapping.Content[i].Value
+		if name == "eh" {
+			// do not process eh element
+			continue
+		}
+
+		if err := d.walkNode(fn, []string{name}, name, mapping.Content[i+1], false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkNode walks the node and the values inside it. Sequence items share the path of the sequence. flow tells if the node is inside a flow collection.
+func (d *yamlDocument) walkNode(fn func(Value) error, names []string, path string, node *yaml.Node, flow bool) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := d.walkNode(fn, names, path, item, flow || node.Style&yaml.FlowStyle != 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.AliasNode:
+		// the value is protected where its anchor is defined
+		return nil
+	case yaml.ScalarNode:
+		if _, ok := yamlTypes[node.ShortTag()]; !ok && node.ShortTag() != "!!str" {
+			// null has nothing to protect
+			return nil
+		}
+	}
+
+	if err := fn(&yamlValue{doc: d, names: names, path: path, node: node, flow: flow}); err != nil {
+		if err == SkipValue {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to process %q", path)
+	}
+
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if err := d.walkNode(fn, append(names[:len(names):len(names)], key), joinPath(path, key), node.Content[i+1], flow || node.Style&yaml.FlowStyle != 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *yamlDocument) replace(start, end int, text []byte) {
+	d.edits = append(d.edits, textEdit{start: start, end: end, text: text})
+}
+
+// yamlValue is a scalar or a mapping in a YAML document.
+type yamlValue struct {
+	doc   *yamlDocument
+	names []string
+	path  string
+	node  *yaml.Node
+	flow  bool
+}
+
+func (v *yamlValue) Names() []string {
+	return v.names
+}
+
+func (v *yamlValue) Path() string {
+	return v.path
+}
+
+func (v *yamlValue) Get() (string, string, error) {
+	d := v.doc
+
+	switch {
+	case v.node.Kind == yaml.MappingNode:
+		start, end := d.mappingSpan(v.node)
+		for _, alias := range d.aliases {
+			if alias.target >= start && alias.target < end && (alias.pos < start || alias.pos >= end) {
+				return "", "", &UnsupportedError{Reason: fmt.Sprintf("mapping with anchor %q used outside of it", alias.name)}
+			}
+		}
+
+		return string(d.text[start:end]), valueTypeYAML, nil
+	case isBlockScalar(v.node):
+		// only the body is protected, the indicator line is kept so that it can be restored exactly
+		return d.blockBody(v.node).text(d.text), ValueString, nil
+	}
+
+	return v.node.Value, yamlTypes[v.node.ShortTag()], nil
+}
+
+func (v *yamlValue) Set(text string, valueType string) error {
+	d := v.doc
+
+	switch {
+	case v.node.Kind == yaml.MappingNode:
+		if valueType != ValueString {
+			return fmt.Errorf("failed, cannot replace mapping %q with %s value", v.path, valueType)
+		}
+
+		start, end := d.mappingSpan(v.node)
+		d.replace(start, end, yamlScalar(text, 0, v.flow))
+	case isBlockScalar(v.node) && valueType == ValueString:
+		body := d.blockBody(v.node)
+		if text == "" {
+			// the lines are removed up to the indicator
+			body.start = lineEnd(d.text, d.contentStart(v.node))
+		}
+		d.replace(body.start, body.end, body.indentText(text))
+	case valueType == ValueString:
+		start, end := d.scalarSpan(v.node, v.flow)
+		d.replace(start, end, yamlScalar(text, v.node.Style, v.flow))
+	case valueType == valueTypeYAML:
+		// the text of the mapping starts where the encrypted string is
+		start, end := d.scalarSpan(v.node, v.flow)
+		d.replace(start, end, []byte(text))
+	default:
+		if !isYAMLType(valueType) {
+			return fmt.Errorf("failed, unknown type %q of %q", valueType, v.path)
+		}
+
+		start, end := d.scalarSpan(v.node, v.flow)
+		d.replace(start, end, []byte(text))
+	}
+
+	return nil
+}
+
+func isYAMLType(valueType string) bool {
+	for _, t := range yamlTypes {
+		if t == valueType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isBlockScalar(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0
+}
+
+// yamlScalar returns the text of the string in the style of the scalar it replaces. Strings that cannot be written in that style are double-quoted.
+func yamlScalar(s string, style yaml.Style, flow bool) []byte {
+	switch {
+	case style&yaml.SingleQuotedStyle != 0 && !strings.Contains(s, "\n"):
+		return []byte("'" + strings.Replace(s, "'", "''", -1) + "'")
+	case style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) == 0 && isPlainYAML(s, flow):
+		return []byte(s)
+	}
+
+	// JSON strings are valid double-quoted YAML scalars
+	return jsonString(s)
+}
+
+// isPlainYAML tells if the string can be written without quotes.
+func isPlainYAML(s string, flow bool) bool {
+	if s == "" || strings.ContainsAny(s, "\n\r") || strings.TrimSpace(s) != s {
+		return false
+	}
+
+	text := "k: " + s
+	if flow {
+		text = "k: [" + s + "]"
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &node); err != nil || len(node.Content) != 1 || len(node.Content[0].Content) != 2 {
+		return false
+	}
+
+	value := node.Content[0].Content[1]
+	if flow {
+		if value.Kind != yaml.SequenceNode || len(value.Content) != 1 {
+			return false
+		}
+		value = value.Content[0]
+	}
+
+	return value.Kind == yaml.ScalarNode && value.ShortTag() == "!!str" && value.Style == 0 && value.Value == s
+}
+
+// contentStart returns the position of the node after its anchor and tag.
+func (d *yamlDocument) contentStart(node *yaml.Node) int {
+	pos := d.offset(node)
+	for pos < len(d.text) && (d.text[pos] == '&' || d.text[pos] == '!') {
+		for pos < len(d.text) && !isYAMLSpace(d.text[pos]) {
+			pos++
+		}
+		for pos < len(d.text) && (d.text[pos] == ' ' || d.text[pos] == '\t') {
+			pos++
+		}
+	}
+
+	return pos
+}
+
+func isYAMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// nextContent returns the position of the first line after pos that is not blank, after its indentation. Comments count as content unless skipComments is set.
+func (d *yamlDocument) nextContent(pos int, skipComments bool) int {
+	for pos < len(d.text) {
+		pos = nextLine(d.text, lineEnd(d.text, pos))
+		content := pos + yamlIndentation(d.text[pos:])
+		if content < len(d.text) && d.text[content] != '\n' && d.text[content] != '\r' && !(skipComments && d.text[content] == '#') {
+			return content
+		}
+	}
+
+	return len(d.text)
+}
+
+// yamlIndentation returns the number of spaces at the start of the line.
+func yamlIndentation(line []byte) int {
+	n := 0
+	for n < len(line) && line[n] == ' ' {
+		n++
+	}
+
+	return n
+}
+
+// atLineEnd tells if only spaces or a comment follow pos on its line.
+func (d *yamlDocument) atLineEnd(pos int) bool {
+	rest := bytes.TrimLeft(d.text[pos:lineEnd(d.text, pos)], " \t\r")
+	return len(rest) == 0 || rest[0] == '#'
+}
+
+// mappingSpan returns the text of the mapping, with the comments before its first key but without its anchor and tag.
+func (d *yamlDocument) mappingSpan(node *yaml.Node) (int, int) {
+	start := d.contentStart(node)
+	if node.Style&yaml.FlowStyle != 0 {
+		return start, d.flowEnd(start)
+	}
+
+	if d.atLineEnd(start) {
+		start = d.nextContent(start, false)
+	} else if lineStart := d.lineStart(start); start == lineStart+yamlIndentation(d.text[lineStart:]) {
+		// comment lines right above the first key belong to the mapping
+		for lineStart > 0 {
+			previous := d.lineStart(lineStart - 1)
+			indent := yamlIndentation(d.text[previous:])
+			if previous+indent >= len(d.text) || d.text[previous+indent] != '#' || indent < start-d.lineStart(start) {
+				break
+			}
+			lineStart = previous
+			start = previous + indent
+		}
+	}
+
+	indent := start - d.lineStart(start)
+	if len(node.Content) > 0 {
+		key := d.offset(node.Content[0])
+		indent = key - d.lineStart(key)
+	}
+
+	return start, d.blockEnd(start, indent)
+}
+
+func (d *yamlDocument) lineStart(pos int) int {
+	return bytes.LastIndexByte(d.text[:pos], '\n') + 1
+}
+
+// blockEnd returns the end of the last line after the line at pos that is indented at least by indent, before a line that is indented less.
+func (d *yamlDocument) blockEnd(pos int, indent int) int {
+	end := lineEnd(d.text, pos)
+	for line := nextLine(d.text, end); line < len(d.text); line = nextLine(d.text, lineEnd(d.text, line)) {
+		content := bytes.TrimRight(d.text[line:lineEnd(d.text, line)], " \t\r")
+		if len(content) == 0 {
+			continue
+		}
+
+		if yamlIndentation(content) < indent {
+			break
+		}
+
+		end = line + len(content)
+	}
+
+	return bytes.LastIndexFunc(d.text[:end], func(r rune) bool { return r != '\r' && r != ' ' && r != '\t' }) + 1
+}
+
+// flowEnd returns the position after the flow collection or quoted scalar that starts at pos.
+func (d *yamlDocument) flowEnd(pos int) int {
+	depth := 0
+	for pos < len(d.text) {
+		switch c := d.text[pos]; c {
+		case '"', '\'':
+			pos = d.quotedEnd(pos)
+			if depth == 0 {
+				return pos
+			}
+			continue
+		case '#':
+			if pos > 0 && isYAMLSpace(d.text[pos-1]) {
+				pos = lineEnd(d.text, pos)
+				continue
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth == 0 {
+				return pos + 1
+			}
+		}
+		pos++
+	}
+
+	return pos
+}
+
+// quotedEnd returns the position after the quoted scalar that starts at pos.
+func (d *yamlDocument) quotedEnd(pos int) int {
+	quote := d.text[pos]
+	for pos++; pos < len(d.text); pos++ {
+		switch {
+		case quote == '"' && d.text[pos] == '\\':
+			pos++
+		case d.text[pos] == quote && quote == '\'' && pos+1 < len(d.text) && d.text[pos+1] == '\'':
+			pos++
+		case d.text[pos] == quote:
+			return pos + 1
+		}
+	}
+
+	return pos
+}
+
+// scalarSpan returns the text of the scalar without its anchor and tag.
+func (d *yamlDocument) scalarSpan(node *yaml.Node, flow bool) (int, int) {
+	start := d.contentStart(node)
+	if d.atLineEnd(start) {
+		start = d.nextContent(start, true)
+	}
+
+	if start >= len(d.text) {
+		return start, start
+	}
+
+	switch d.text[start] {
+	case '"', '\'':
+		return start, d.quotedEnd(start)
+	}
+
+	// plain scalars end before a comment, or a flow indicator in flow collections, and continue on more indented lines
+	end := start
+	for {
+		line := d.text[end:lineEnd(d.text, end)]
+		n := len(line)
+		if i := bytes.Index(line, []byte(" #")); i >= 0 {
+			n = i
+		}
+		if flow {
+			if i := bytes.IndexAny(line[:n], ",]}"); i >= 0 {
+				n = i
+			}
+		}
+		end += len(bytes.TrimRight(line[:n], " \t\r"))
+
+		if n < len(line) || len(strings.Fields(string(d.text[start:end]))) >= len(strings.Fields(node.Value)) {
+			return start, end
+		}
+
+		next := d.nextContent(end, true)
+		if next >= len(d.text) {
+			return start, end
+		}
+		end = next
+	}
+}
+
+// yamlBlock is the body of a literal or folded block scalar, the lines after its indicator.
+type yamlBlock struct {
+	start, end int
+	indent     int
+
+	// empty is set if the scalar has no lines, then the body starts at the end of the indicator line
+	empty bool
+}
+
+// blockBody returns the body of the block scalar. It is empty after the indicator line if the scalar has no lines.
+func (d *yamlDocument) blockBody(node *yaml.Node) yamlBlock {
+	indicator := d.contentStart(node)
+	lineStart := d.lineStart(indicator)
+	parentIndent := yamlIndentation(d.text[lineStart:])
+
+	body := yamlBlock{start: nextLine(d.text, lineEnd(d.text, indicator)), indent: parentIndent + 2}
+	body.end = body.start
+
+	minIndent := -1
+	for line := body.start; line < len(d.text); line = nextLine(d.text, lineEnd(d.text, line)) {
+		content := bytes.TrimRight(d.text[line:lineEnd(d.text, line)], " \t\r")
+		if len(content) == 0 {
+			continue
+		}
+
+		indent := yamlIndentation(content)
+		if indent <= parentIndent {
+			break
+		}
+
+		if minIndent < 0 || indent < minIndent {
+			minIndent = indent
+		}
+		body.end = line + len(content)
+	}
+
+	if minIndent >= 0 {
+		body.indent = minIndent
+	}
+
+	if body.end == body.start && body.start > indicator {
+		body.start = lineEnd(d.text, indicator)
+		body.end = body.start
+		body.empty = true
+	}
+
+	return body
+}
+
+// text returns the lines of the body without their indentation.
+func (b yamlBlock) text(text []byte) string {
+	if b.empty {
+		return ""
+	}
+
+	lines := strings.Split(string(text[b.start:b.end]), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if len(line) >= b.indent {
+			line = line[b.indent:]
+		} else {
+			line = strings.TrimLeft(line, " ")
+		}
+		lines[i] = line
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// indentText returns the lines of the text with the indentation of the body. Empty lines are kept empty.
+func (b yamlBlock) indentText(text string) []byte {
+	var result bytes.Buffer
+	if b.empty && text != "" {
+		result.WriteByte('\n')
+	}
+
+	indent := strings.Repeat(" ", b.indent)
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			result.WriteByte('\n')
+		}
+		if line != "" {
+			result.WriteString(indent + line)
+		}
+	}
+
+	return result.Bytes()
+}
+
+func (d *yamlDocument) SetHeaderValue(name string, value interface{}) error {
+	header, _, err := d.headerNode()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(header.Content); i += 2 {
+		if header.Content[i].Value != name {
+			continue
+		}
+
+		node := header.Content[i+1]
+		start, end := d.scalarSpan(node, header.Style&yaml.FlowStyle != 0)
+		switch v := value.(type) {
+		case string:
+			d.replace(start, end, yamlScalar(v, node.Style, header.Style&yaml.FlowStyle != 0))
+		case bool:
+			d.replace(start, end, []byte(strconv.FormatBool(v)))
+		default:
+			return fmt.Errorf("failed, unsupported value of %q: %v", name, value)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("failed, no %q element found in 'eh'", name)
+}
+
+func (d *yamlDocument) RemoveHeader() error {
+	header, key, err := d.headerNode()
+	if err != nil {
+		return nil
+	}
+
+	// the lines of the header are removed together with earlier changes to it
+	start := d.lineStart(d.offset(key))
+	_, end := d.mappingSpan(header)
+	d.edits = removeEdits(d.edits, []textEdit{{start: start, end: nextLine(d.text, lineEnd(d.text, end))}})
+	return nil
+}
+
+func (d *yamlDocument) Bytes() ([]byte, error) {
+	return applyEdits(d.text, d.edits)
+}
+
+// Decode decodes the text of the document with yaml.v3.
+func (d *yamlDocument) Decode(out interface{}) error {
+	text, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(text)
+
+	err = yaml.Unmarshal(text, out)
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		var line int
+		if _, scanErr := fmt.Sscanf(typeErr.Errors[0], "line %d:", &line); scanErr == nil {
+			if path := yamlPathAt(text, line); path != "" {
+				return &DecodeError{Path: path, Err: err}
+			}
+		}
+	}
+
+	return err
+}
+
+// yamlPathAt returns the path of the innermost value at the line of the text, or "".
+func yamlPathAt(text []byte, line int) string {
+	doc, err := parseYAML(text)
+	if err != nil {
+		return ""
+	}
+	defer wipe(doc.text)
+
+	var path string
+	doc.Walk(func(v Value) error {
+		if v.(*yamlValue).node.Line == line {
+			path = v.Path()
+		}
+		return nil
+	})
+
+	return path
+}
+
+// Merge merges the entries of the fragment mapping into the document. The merged nodes are written as a new document.
+func (d *yamlDocument) Merge(fragment Document, resolve func(path string) bool) error {
+	f, ok := fragment.(*yamlDocument)
+	if !ok {
+		return errNotMergeable
+	}
+
+	text, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(text)
+
+	fragmentText, err := f.Bytes()
+	if err != nil {
+		return err
+	}
+	defer wipe(fragmentText)
+
+	merged, err := parseYAML(text)
+	if err != nil {
+		return err
+	}
+
+	parsedFragment, err := parseYAML(fragmentText)
+	if err != nil {
+		return err
+	}
+
+	mapping, err := merged.mapping()
+	if err != nil {
+		return err
+	}
+
+	fragmentMapping, err := parsedFragment.mapping()
+	if err != nil {
+		return err
+	}
+
+	indent := yamlIndent(text)
+	if err := mergeYAMLMapping(mapping, fragmentMapping, "", indent, resolve); err != nil {
+		return err
+	}
+
+	result, err := encodeYAML(merged.root, indent)
+	if err != nil {
+		return err
+	}
+
+	merged, err = parseYAML(result)
+	wipe(result)
+	if err != nil {
+		return err
+	}
+
+	d.text, d.root, d.lines, d.aliases, d.edits = merged.text, merged.root, merged.lines, merged.aliases, nil
+	return nil
+}
+
+func mergeYAMLMapping(mapping *yaml.Node, fragment *yaml.Node, path string, indent int, resolve func(path string) bool) error {
+	for i := 0; i+1 < len(fragment.Content); i += 2 {
+		key, value := fragment.Content[i], fragment.Content[i+1]
+		keyPath := joinPath(path, key.Value)
+
+		var existing *yaml.Node
+		for j := 0; j+1 < len(mapping.Content); j += 2 {
+			if mapping.Content[j].Value == key.Value {
+				existing = mapping.Content[j+1]
+			}
+		}
+
+		if existing == nil {
+			mapping.Content = append(mapping.Content, key, value)
+			continue
+		}
+
+		if existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			if err := mergeYAMLMapping(existing, value, keyPath, indent, resolve); err != nil {
+				return err
+			}
+			continue
+		}
+
+		same, err := sameYAMLNodes(existing, value, indent)
+		if err != nil {
+			return errors.Wrapf(err, "failed to compare %q", keyPath)
+		}
+
+		if !same && resolve(keyPath) {
+			// aliases point to the node, so it is replaced in place
+			anchor := existing.Anchor
+			*existing = *value
+			if existing.Anchor == "" {
+				existing.Anchor = anchor
+			}
+		}
+	}
+
+	return nil
+}
+
+func sameYAMLNodes(a *yaml.Node, b *yaml.Node, indent int) (bool, error) {
+	textA, err := encodeYAML(a, indent)
+	if err != nil {
+		return false, err
+	}
+	defer wipe(textA)
+
+	textB, err := encodeYAML(b, indent)
+	if err != nil {
+		return false, err
+	}
+	defer wipe(textB)
+
+	return bytes.Equal(textA, textB), nil
+}
+
+// yamlIndent returns the smallest indentation used in the contents.
+func yamlIndent(contents []byte) int {
+	indent := 0
+	for _, line := range strings.Split(string(contents), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		n := len(line) - len(trimmed)
+		if n > 0 && trimmed != "" && !strings.HasPrefix(trimmed, "#") && (indent == 0 || n < indent) {
+			indent = n
+		}
+	}
+
+	if indent < 2 {
+		return 2
+	}
+
+	return indent
+}
+
+// encodeYAML writes the node with yaml.v3. Merge keys are written without the explicit tag that yaml.v3 adds.
+func encodeYAML(node *yaml.Node, indent int) ([]byte, error) {
+	var result bytes.Buffer
+	encoder := yaml.NewEncoder(&result)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return bytes.Replace(result.Bytes(), []byte("!!merge <<:"), []byte("<<:"), -1), nil
+}